@@ -5,10 +5,11 @@ import compression from 'compression';
 import { createServer } from 'http';
 import { Server } from 'socket.io';
 
-import { config } from './config/env';
+import { config, configOverrides, redactConfig } from './config/env';
 import { errorHandler, notFoundHandler } from './middlewares/errorHandler';
 import { requestLogger } from './middlewares/requestLogger';
 import { apiLimiter } from './middlewares/rateLimiter';
+import { authenticateToken, requireAdmin } from './middlewares/auth';
 import { logger } from './utils/logger';
 import { database } from './database/prisma';
 
@@ -105,10 +106,27 @@ export class App {
           tests: '/api/tests',
           otel: '/api/otel',
           telemetry: '/api/telemetry',
+          config: '/api/config',
           health: '/health',
         },
       });
     });
+
+    // Effective configuration for debugging env overrides (admin only, secrets redacted)
+    this.app.get('/api/config', authenticateToken, requireAdmin, (req, res) => {
+      const { ingestionSettings } = require('./routes/otel.routes');
+      const { clickHouseService } = require('./services/clickhouseService');
+      const { sinkSettings } = require('./services/telemetrySink');
+      const { metricsConfig } = require('./utils/metrics');
+      res.json({
+        config: redactConfig(config),
+        ingestion: redactConfig(ingestionSettings()),
+        clickhouse: redactConfig(clickHouseService.getConfig()),
+        sinks: redactConfig(sinkSettings),
+        metrics: redactConfig(metricsConfig),
+        overrides: redactConfig(configOverrides()),
+      });
+    });
   }
 
   private initializeErrorHandling(): void {
@@ -148,4 +166,14 @@ export class App {
       logger.info(`Server is running on port ${config.port} in ${config.env} mode`);
     });
   }
+
+  // Stops accepting connections and resolves once in-flight requests have finished
+  public close(): Promise<void> {
+    return new Promise((resolve, reject) => {
+      if (!this.server.listening) return resolve();
+      this.io.disconnectSockets(true);
+      this.server.close((error) => (error ? reject(error) : resolve()));
+      this.server.closeIdleConnections();
+    });
+  }
 }
\ No newline at end of file
@@ -120,4 +120,37 @@ export const config = {
     enableBulkOperations: envVars.ENABLE_BULK_OPERATIONS,
     enableScheduledReports: envVars.ENABLE_SCHEDULED_REPORTS,
   },
-};
\ No newline at end of file
+};
+
+const SECRET_KEY_PATTERN = /(password|secret|key|token|webhook|headers)/i;
+
+// Values under these keys are user data such as metric names, not settings, so their
+// keys aren't matched against the secret pattern
+const DATA_KEYS = new Set(['metricNameMap']);
+
+// Masks the userinfo part of URLs such as redis://:pass@host
+const redactUrlCredentials = (value: string): string =>
+  value.replace(/^([a-z][a-z0-9+.-]*:\/\/)[^/@\s]+@/i, '$1[REDACTED]@');
+
+// Deep copy of a config object with credential-like values masked
+export const redactConfig = (value: any): any => {
+  if (typeof value === 'string') {
+    return redactUrlCredentials(value);
+  }
+  if (Array.isArray(value)) {
+    return value.map(redactConfig);
+  }
+  if (value && typeof value === 'object') {
+    return Object.fromEntries(
+      Object.entries(value).map(([key, entry]) => [
+        key,
+        DATA_KEYS.has(key) ? entry : SECRET_KEY_PATTERN.test(key) && entry ? '[REDACTED]' : redactConfig(entry),
+      ]),
+    );
+  }
+  return value;
+};
+
+// OTEL_*/CLICKHOUSE_* are read outside the validated config, so list what was set too
+export const configOverrides = (env: NodeJS.ProcessEnv = process.env): Record<string, string | undefined> =>
+  Object.fromEntries(Object.entries(env).filter(([key]) => /^(OTEL_|CLICKHOUSE_)/.test(key) || key === 'DRY_RUN'));
@@ -59,6 +59,11 @@ export class RedisClient {
     await this.client.setex(key, ttlSeconds, JSON.stringify(value));
   }
 
+  public async set(key: string, value: any): Promise<void> {
+    if (!this.client) return;
+    await this.client.set(key, JSON.stringify(value));
+  }
+
   public async get<T>(key: string): Promise<T | null> {
     if (!this.client) return null;
     const value = await this.client.get(key);
@@ -4,49 +4,61 @@ import { App } from './app';
 import { logger } from './utils/logger';
 import { config } from './config/env';
 import { clickHouseService } from './services/clickhouseService';
+import { runShutdownPhases, shutdownPhases } from './utils/shutdown';
 
 // Initialize OpenTelemetry first
 const otelSDK = initializeOTel();
 
+let app: App | undefined;
+
+const shutdown = async (exitCode: number) => {
+  await runShutdownPhases(
+    shutdownPhases({
+      server: app,
+      telemetry: { shutdown: () => shutdownOTel(otelSDK) },
+      clickhouse: clickHouseService,
+    }),
+  );
+
+  process.exit(exitCode);
+};
+
 process.on('uncaughtException', async (error: Error) => {
   logger.error('Uncaught Exception:', error);
-  await shutdownOTel(otelSDK);
-  await clickHouseService.close();
-  process.exit(1);
+  await shutdown(1);
 });
 
 process.on('unhandledRejection', async (reason: any) => {
   logger.error('Unhandled Rejection:', reason);
-  await shutdownOTel(otelSDK);
-  await clickHouseService.close();
-  process.exit(1);
+  await shutdown(1);
 });
 
 process.on('SIGTERM', async () => {
   logger.info('SIGTERM received, shutting down gracefully');
-  await shutdownOTel(otelSDK);
-  await clickHouseService.close();
-  process.exit(0);
+  await shutdown(0);
 });
 
 process.on('SIGINT', async () => {
   logger.info('SIGINT received, shutting down gracefully');
-  await shutdownOTel(otelSDK);
-  await clickHouseService.close();
-  process.exit(0);
+  await shutdown(0);
 });
 
 const startServer = async () => {
   try {
     // Check ClickHouse connection
-    const isClickHouseHealthy = await clickHouseService.ping();
+    const isClickHouseHealthy = await clickHouseService.waitForConnection();
     if (!isClickHouseHealthy) {
       logger.warn('ClickHouse is not available - telemetry data will not be stored');
     } else {
       logger.info('ClickHouse connection established');
     }
 
-    const app = new App();
+    // Optionally fail fast if telemetry tables can't be written to
+    if (process.env.CLICKHOUSE_SELF_TEST === 'true') {
+      await clickHouseService.selfTest();
+    }
+
+    app = new App();
     await app.initialize();
     app.listen();
   } catch (error) {
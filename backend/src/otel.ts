@@ -13,7 +13,13 @@ import { logger } from './utils/logger';
 
 const OTEL_ENDPOINT = process.env.OTEL_EXPORTER_OTLP_ENDPOINT || 'http://localhost:4318';
 
-export const initializeOTel = (): any => {
+export interface OTelProviders {
+  traceProvider: { shutdown(): Promise<void> };
+  // Absent when initialization failed and only the fallback tracer was registered
+  meterProvider?: { shutdown(): Promise<void> };
+}
+
+export const initializeOTel = (): OTelProviders => {
   try {
     // Create OTLP trace exporter with debugging
     const traceExporter = new OTLPTraceExporter({
@@ -65,15 +71,13 @@ export const initializeOTel = (): any => {
     // Return a basic provider that won't crash the app
     const fallbackProvider = new traceSDK.NodeTracerProvider();
     fallbackProvider.register();
-    return fallbackProvider;
+    return { traceProvider: fallbackProvider };
   }
 };
 
-export const shutdownOTel = async (provider: any): Promise<void> => {
-  try {
-    await provider.shutdown();
-    logger.info('OpenTelemetry shutdown complete');
-  } catch (error) {
-    logger.error('Error during OpenTelemetry shutdown', { error });
-  }
+// Flushes pending spans and the last metric export. Failures propagate so the
+// shutdown phase reports them.
+export const shutdownOTel = async ({ traceProvider, meterProvider }: OTelProviders): Promise<void> => {
+  await Promise.all([traceProvider.shutdown(), meterProvider?.shutdown()]);
+  logger.info('OpenTelemetry shutdown complete');
 };
\ No newline at end of file
@@ -4,11 +4,11 @@ import { validate, validateQuery, validateParams } from '../middlewares/validati
 import { Environment, CreateApplicationInput, UpdateApplicationInput } from '../types';
 import { prisma } from '../database/prisma';
 import { logger } from '../utils/logger';
-import { metrics } from '@opentelemetry/api';
+import { AppMeter } from '../utils/metrics';
 import Joi from 'joi';
 
 // Create a meter for custom metrics
-const meter = metrics.getMeter('appsentry-backend', '1.0.0');
+const meter = new AppMeter('appsentry-backend', '1.0.0');
 
 // Create custom metrics
 const applicationRequestCounter = meter.createCounter('application_requests_total', {
@@ -1,11 +1,128 @@
-import { Router, Request, Response } from 'express';
+import { Router, Request, Response, NextFunction } from 'express';
+import os from 'os';
 import { logger } from '../utils/logger';
-import { clickHouseService, TraceData, MetricData, LogData } from '../services/clickhouseService';
+import { AppMeter } from '../utils/metrics';
+import { logThrottledError } from '../utils/throttledLogger';
+import { recentErrors } from '../utils/recentErrors';
+import { RollingErrorRate } from '../utils/errorRate';
+import { ServiceTracker } from '../utils/serviceTracker';
+import { CircuitOpenError, CircuitState } from '../utils/circuitBreaker';
+import { telemetrySink } from '../services/telemetrySink';
+import { SignalToggles } from '../services/signalToggles';
+import { createGeoIpLookup, loadGeoIpConfig } from '../services/geoIp';
+import { RedisClient, redisClient } from '../database/redis';
+import {
+  loadOtlpTransformConfig,
+  OtlpTransformer,
+  Signal,
+  TransformStats,
+} from '../services/otlpTransform';
+import { authenticateToken, requireAdmin } from '../middlewares/auth';
+import {
+  clickHouseService,
+  isRetryableWriteError,
+  TraceData,
+  MetricData,
+  LogData,
+} from '../services/clickhouseService';
+
+// Create a meter for ingestion metrics. Routes load after otel.ts registers the global
+// MeterProvider, so instruments that services report through are created here.
+const meter = new AppMeter('appsentry-otel-ingestion', '1.0.0');
+
+const invalidRecordsCounter = meter.createCounter(meter.namespaced('invalid_records_total'), {
+  description: 'OTLP records dropped as invalid, by signal and reason',
+});
+
+const droppedPointsCounter = meter.createCounter(meter.namespaced('dropped_points_total'), {
+  description: 'Valid metric points not stored, by reason',
+});
+
+const zeroTimeSpansCounter = meter.createCounter(meter.namespaced('zero_time_spans_total'), {
+  description: 'Spans stored with a zero start or end time, by reason',
+});
+
+const skippedAttributesCounter = meter.createCounter(meter.namespaced('skipped_attributes_total'), {
+  description: 'OTLP attributes skipped for a missing key or value',
+});
+
+const droppedAttributesCounter = meter.createCounter(meter.namespaced('dropped_attributes_total'), {
+  description: 'Span and log attributes dropped by the attribute cap or upstream',
+});
 
 const router = Router();
 
+// Retryable storage failures are the exporter's to retry and would otherwise pull every
+// replica out of rotation at once while ClickHouse is down, so they don't count
+const ERROR_RATE_WINDOW_MS = parseInt(process.env.OTEL_ERROR_RATE_WINDOW_MS || '60000');
+const ingestionErrorRate = new RollingErrorRate(ERROR_RATE_WINDOW_MS, isRetryableWriteError);
+
+// Runtime write toggles for incident mitigation, shared through Redis when it is enabled.
+// Disabled signals are dropped (acknowledged but not written) or rejected with 503 so the
+// exporter buffers and retries.
+const signalToggles = new SignalToggles(
+  redisClient ? RedisClient.getInstance() : undefined,
+  parseInt(process.env.OTEL_SIGNAL_TOGGLES_REFRESH_MS || '5000'),
+);
+void signalToggles.refresh();
+
+// Reported with the toggles so per-instance changes show which replica they applied to
+const INSTANCE_NAME = process.env.POD_NAME || process.env.HOSTNAME || os.hostname();
+const DISABLED_SIGNAL_POLICY = process.env.OTEL_DISABLED_SIGNAL_POLICY === 'reject' ? 'reject' : 'drop';
+
+// Retry-After sent with 503 responses when there is no better estimate
+const RETRY_AFTER_SECONDS = parseInt(process.env.OTEL_RETRY_AFTER_SECONDS || '5');
+
+const requireSignalEnabled = (signal: Signal) => (req: Request, res: Response, next: NextFunction) => {
+  if (signalToggles.isEnabled(signal)) {
+    return next();
+  }
+  if (DISABLED_SIGNAL_POLICY === 'reject') {
+    res.set('Retry-After', RETRY_AFTER_SECONDS.toString());
+    return res.status(503).json({ error: `Ingestion of ${signal} is temporarily disabled` });
+  }
+  return res.status(200).json({ success: true, dropped: true });
+};
+
+// OTLP/HTTP exporters retry 503 (honouring Retry-After) but discard batches answered with 500,
+// so an unavailable or overloaded ClickHouse is reported as 503 and only real failures as 500
+const sendIngestionError = (res: Response, signal: Signal, error: unknown) => {
+  if (isRetryableWriteError(error)) {
+    const retryAfterMs = error instanceof CircuitOpenError ? error.retryAfterMs : 0;
+    const retryAfter = retryAfterMs > 0 ? Math.ceil(retryAfterMs / 1000) : RETRY_AFTER_SECONDS;
+    res.set('Retry-After', retryAfter.toString());
+    return res.status(503).json({ error: `Storage is temporarily unavailable, retry ${signal} later` });
+  }
+  return res.status(500).json({ error: `Failed to process ${signal}` });
+};
+
+const geoIpConfig = loadGeoIpConfig();
+const otlpTransformer = new OtlpTransformer(loadOtlpTransformConfig(), createGeoIpLookup(geoIpConfig));
+
+const MAX_TRACKED_SERVICES = parseInt(process.env.OTEL_MAX_TRACKED_SERVICES || '1000');
+const DISTINCT_SERVICES_WINDOW_MS = parseInt(process.env.OTEL_DISTINCT_SERVICES_WINDOW_MS || '3600000');
+const serviceTracker = new ServiceTracker(MAX_TRACKED_SERVICES, DISTINCT_SERVICES_WINDOW_MS);
+
+// Records what the transformer saw and dropped for one request
+const reportBatch = (signal: Signal, batch: TransformStats): void => {
+  batch.services.forEach((serviceName) => serviceTracker.markSeen(serviceName));
+
+  if (Object.keys(batch.invalid).length > 0) {
+    logger.warn(`Dropped invalid ${signal} records`, { reasons: batch.invalid });
+  }
+  Object.entries(batch.invalid).forEach(([reason, count]) => {
+    invalidRecordsCounter.add(count, { signal, reason });
+  });
+  Object.entries(batch.skippedAttributes).forEach(([reason, count]) => {
+    skippedAttributesCounter.add(count, { reason });
+  });
+  Object.entries(batch.droppedAttributes).forEach(([reason, count]) => {
+    droppedAttributesCounter.add(count, { signal, reason });
+  });
+};
+
 // OTEL Traces endpoint
-router.post('/v1/traces', async (req: Request, res: Response) => {
+router.post('/v1/traces', requireSignalEnabled('traces'), async (req: Request, res: Response) => {
   try {
     const traces = req.body;
     
@@ -16,96 +133,34 @@ router.post('/v1/traces', async (req: Request, res: Response) => {
     });
 
     // Transform and store traces in ClickHouse
-    const traceData: TraceData[] = [];
-    
-    if (traces?.resourceSpans) {
-      traces.resourceSpans.forEach((resourceSpan: any) => {
-        const resourceAttributes = resourceSpan.resource?.attributes || {};
-        const serviceName = resourceAttributes.find((attr: any) => attr.key === 'service.name')?.value?.stringValue || 'unknown';
-        const serviceVersion = resourceAttributes.find((attr: any) => attr.key === 'service.version')?.value?.stringValue || '1.0.0';
-        
-        resourceSpan.scopeSpans?.forEach((scopeSpan: any) => {
-          scopeSpan.spans?.forEach((span: any) => {
-            // Convert nanoseconds to milliseconds
-            const startTime = new Date(parseInt(span.startTimeUnixNano) / 1000000);
-            const endTime = new Date(parseInt(span.endTimeUnixNano) / 1000000);
-            const duration = parseInt(span.endTimeUnixNano) - parseInt(span.startTimeUnixNano);
-            
-            const spanAttributes: Record<string, string> = {};
-            span.attributes?.forEach((attr: any) => {
-              if (attr.value?.stringValue !== undefined) {
-                spanAttributes[attr.key] = attr.value.stringValue;
-              } else if (attr.value?.intValue !== undefined) {
-                spanAttributes[attr.key] = attr.value.intValue.toString();
-              } else if (attr.value?.boolValue !== undefined) {
-                spanAttributes[attr.key] = attr.value.boolValue.toString();
-              } else if (attr.value?.doubleValue !== undefined) {
-                spanAttributes[attr.key] = attr.value.doubleValue.toString();
-              } else {
-                spanAttributes[attr.key] = '';
-              }
-            });
-
-            const resourceAttrs: Record<string, string> = {};
-            resourceAttributes.forEach((attr: any) => {
-              resourceAttrs[attr.key] = attr.value?.stringValue || attr.value?.intValue?.toString() || '';
-            });
-
-            // Override service name if it's the auto-detected one
-            let cleanServiceName = serviceName;
-            if (serviceName.includes('unknown_service:') || serviceName.includes('/node')) {
-              cleanServiceName = 'AppSentry Backend';
-              resourceAttrs['service.name'] = 'AppSentry Backend';
-            }
-
-            // Create minimal trace record with only required fields
-            const traceRecord: any = {
-              Timestamp: startTime.toISOString().replace('T', ' ').replace('Z', ''),
-              TraceId: span.traceId || '',
-              SpanId: span.spanId || '',
-              ParentSpanId: span.parentSpanId || '',
-              TraceState: '',
-              SpanName: span.name || '',
-              SpanKind: span.kind?.toString() || '1',
-              ServiceName: cleanServiceName || 'unknown',
-              ResourceAttributes: resourceAttrs,
-              ScopeName: scopeSpan.scope?.name || '',
-              ScopeVersion: scopeSpan.scope?.version || '',
-              SpanAttributes: spanAttributes,
-              Duration: duration,
-              StatusCode: span.status?.code?.toString() || '0',
-              StatusMessage: span.status?.message || ''
-            };
-
-            // Add array fields as empty arrays (flattened format)
-            traceRecord['Events.Timestamp'] = [];
-            traceRecord['Events.Name'] = [];
-            traceRecord['Events.Attributes'] = [];
-            traceRecord['Links.TraceId'] = [];
-            traceRecord['Links.SpanId'] = [];
-            traceRecord['Links.TraceState'] = [];
-            traceRecord['Links.Attributes'] = [];
-
-            traceData.push(traceRecord);
-          });
-        });
-      });
+    const batch = otlpTransformer.transformTraces(traces);
+    const traceData: TraceData[] = batch.rows;
+
+    reportBatch('traces', batch);
+    Object.entries(batch.zeroTime).forEach(([reason, count]) => {
+      zeroTimeSpansCounter.add(count, { reason });
+    });
+    if (Object.keys(batch.zeroTime).length > 0) {
+      logger.warn('Stored spans with zero start or end time', { reasons: batch.zeroTime });
     }
 
-    // Insert traces into ClickHouse
+    // Write traces to the configured sink
     if (traceData.length > 0) {
-      await clickHouseService.insertTraces(traceData);
+      await telemetrySink.writeTraces(traceData);
     }
 
+    ingestionErrorRate.record(false);
     res.status(200).json({ success: true });
   } catch (error) {
-    logger.error('Failed to process OTEL traces', { error });
-    res.status(500).json({ error: 'Failed to process traces' });
+    logThrottledError('otel-process-traces', 'Failed to process OTEL traces', { error });
+    recentErrors.record('otel-ingestion', 'traces', error);
+    ingestionErrorRate.recordFailure(error);
+    sendIngestionError(res, 'traces', error);
   }
 });
 
 // OTEL Metrics endpoint  
-router.post('/v1/metrics', async (req: Request, res: Response) => {
+router.post('/v1/metrics', requireSignalEnabled('metrics'), async (req: Request, res: Response) => {
   try {
     const metrics = req.body;
     
@@ -115,133 +170,43 @@ router.post('/v1/metrics', async (req: Request, res: Response) => {
     });
 
     // Transform and store metrics in ClickHouse with correct schema
-    const metricData: any[] = [];
-    
-    if (metrics?.resourceMetrics) {
-      metrics.resourceMetrics.forEach((resourceMetric: any) => {
-        const resourceAttributes = resourceMetric.resource?.attributes || {};
-        
-        const resourceAttrs: Record<string, string> = {};
-        resourceAttributes.forEach((attr: any) => {
-          resourceAttrs[attr.key] = attr.value?.stringValue || attr.value?.intValue?.toString() || '';
-        });
-
-        // Override service name if needed for metrics
-        const serviceName = resourceAttributes.find((attr: any) => attr.key === 'service.name')?.value?.stringValue || 'unknown';
-        if (serviceName.includes('unknown_service:') || serviceName.includes('/node')) {
-          resourceAttrs['service.name'] = 'AppSentry Backend';
-        }
-        
-        resourceMetric.scopeMetrics?.forEach((scopeMetric: any) => {
-          scopeMetric.metrics?.forEach((metric: any) => {
-            
-            // Process sum metrics (counters)
-            if (metric.sum?.dataPoints) {
-              metric.sum.dataPoints.forEach((dataPoint: any) => {
-                const timestamp = new Date(parseInt(dataPoint.timeUnixNano) / 1000000);
-                const startTime = new Date(parseInt(dataPoint.startTimeUnixNano) / 1000000);
-                
-                const metricAttrs: Record<string, string> = {};
-                dataPoint.attributes?.forEach((attr: any) => {
-                  if (attr.value?.stringValue !== undefined) {
-                    metricAttrs[attr.key] = attr.value.stringValue;
-                  } else if (attr.value?.intValue !== undefined) {
-                    metricAttrs[attr.key] = attr.value.intValue.toString();
-                  }
-                });
-
-                const metricRecord = {
-                  ResourceAttributes: resourceAttrs,
-                  ResourceSchemaUrl: '',
-                  ScopeName: scopeMetric.scope?.name || '',
-                  ScopeVersion: scopeMetric.scope?.version || '',
-                  ScopeAttributes: {},
-                  ScopeDroppedAttrCount: 0,
-                  ScopeSchemaUrl: '',
-                  MetricName: metric.name,
-                  MetricDescription: metric.description || '',
-                  MetricUnit: metric.unit || '',
-                  Attributes: metricAttrs,
-                  StartTimeUnix: startTime.toISOString().replace('T', ' ').replace('Z', ''),
-                  TimeUnix: timestamp.toISOString().replace('T', ' ').replace('Z', ''),
-                  Value: dataPoint.asDouble || dataPoint.asInt || 0,
-                  Flags: 0,
-                  'Exemplars.FilteredAttributes': [],
-                  'Exemplars.TimeUnix': [],
-                  'Exemplars.Value': [],
-                  'Exemplars.SpanId': [],
-                  'Exemplars.TraceId': [],
-                  AggTemp: 0,
-                  IsMonotonic: true
-                };
-
-                metricData.push(metricRecord);
-              });
-            }
-
-            // Process histogram metrics
-            if (metric.histogram?.dataPoints) {
-              metric.histogram.dataPoints.forEach((dataPoint: any) => {
-                const timestamp = new Date(parseInt(dataPoint.timeUnixNano) / 1000000);
-                const startTime = new Date(parseInt(dataPoint.startTimeUnixNano) / 1000000);
-                
-                const metricAttrs: Record<string, string> = {};
-                dataPoint.attributes?.forEach((attr: any) => {
-                  if (attr.value?.stringValue !== undefined) {
-                    metricAttrs[attr.key] = attr.value.stringValue;
-                  } else if (attr.value?.intValue !== undefined) {
-                    metricAttrs[attr.key] = attr.value.intValue.toString();
-                  }
-                });
-
-                // For histograms, store the sum as a sum metric
-                const metricRecord = {
-                  ResourceAttributes: resourceAttrs,
-                  ResourceSchemaUrl: '',
-                  ScopeName: scopeMetric.scope?.name || '',
-                  ScopeVersion: scopeMetric.scope?.version || '',
-                  ScopeAttributes: {},
-                  ScopeDroppedAttrCount: 0,
-                  ScopeSchemaUrl: '',
-                  MetricName: metric.name,
-                  MetricDescription: metric.description || '',
-                  MetricUnit: metric.unit || '',
-                  Attributes: metricAttrs,
-                  StartTimeUnix: startTime.toISOString().replace('T', ' ').replace('Z', ''),
-                  TimeUnix: timestamp.toISOString().replace('T', ' ').replace('Z', ''),
-                  Value: dataPoint.sum || 0,
-                  Flags: 0,
-                  'Exemplars.FilteredAttributes': [],
-                  'Exemplars.TimeUnix': [],
-                  'Exemplars.Value': [],
-                  'Exemplars.SpanId': [],
-                  'Exemplars.TraceId': [],
-                  AggTemp: 0,
-                  IsMonotonic: false
-                };
-
-                metricData.push(metricRecord);
-              });
-            }
-          });
-        });
-      });
+    const batch = otlpTransformer.transformMetrics(metrics);
+    const metricRows: MetricData[] = batch.rows;
+
+    reportBatch('metrics', batch);
+    Object.entries(batch.droppedPoints).forEach(([reason, count]) => {
+      droppedPointsCounter.add(count, { reason });
+    });
+    if (batch.droppedPoints.drop_rule) {
+      logger.debug('Dropped metric points matching drop rules', { count: batch.droppedPoints.drop_rule });
+    }
+    if (Object.keys(batch.truncated).length > 0) {
+      logger.warn('Truncated metrics exceeding the per-metric point cap', { dropped: batch.truncated });
+    }
+    if (batch.droppedPoints.no_recorded_value) {
+      logger.debug('Skipped metric points without recorded values', { count: batch.droppedPoints.no_recorded_value });
+    }
+    if (batch.droppedPoints.out_of_order) {
+      logger.debug('Dropped out-of-order delta points', { count: batch.droppedPoints.out_of_order });
     }
 
-    // Insert metrics into ClickHouse
-    if (metricData.length > 0) {
-      await clickHouseService.insertMetrics(metricData);
+    // Write metrics to the configured sink
+    if (metricRows.length > 0) {
+      await telemetrySink.writeMetrics(metricRows);
     }
 
+    ingestionErrorRate.record(false);
     res.status(200).json({ success: true });
   } catch (error) {
-    logger.error('Failed to process OTEL metrics', { error });
-    res.status(500).json({ error: 'Failed to process metrics' });
+    logThrottledError('otel-process-metrics', 'Failed to process OTEL metrics', { error });
+    recentErrors.record('otel-ingestion', 'metrics', error);
+    ingestionErrorRate.recordFailure(error);
+    sendIngestionError(res, 'metrics', error);
   }
 });
 
 // OTEL Logs endpoint
-router.post('/v1/logs', async (req: Request, res: Response) => {
+router.post('/v1/logs', requireSignalEnabled('logs'), async (req: Request, res: Response) => {
   try {
     const logs = req.body;
     
@@ -251,69 +216,26 @@ router.post('/v1/logs', async (req: Request, res: Response) => {
     });
 
     // Transform and store logs in ClickHouse
-    const logData: any[] = [];
-    
-    if (logs?.resourceLogs) {
-      logs.resourceLogs.forEach((resourceLog: any) => {
-        const resourceAttributes = resourceLog.resource?.attributes || {};
-        const serviceName = resourceAttributes.find((attr: any) => attr.key === 'service.name')?.value?.stringValue || 'unknown';
-        
-        const resourceAttrs: Record<string, string> = {};
-        resourceAttributes.forEach((attr: any) => {
-          resourceAttrs[attr.key] = attr.value?.stringValue || attr.value?.intValue?.toString() || '';
-        });
-
-        // Override service name if needed
-        if (serviceName.includes('unknown_service:') || serviceName.includes('/node')) {
-          resourceAttrs['service.name'] = 'AppSentry Backend';
-        }
-        
-        resourceLog.scopeLogs?.forEach((scopeLog: any) => {
-          scopeLog.logRecords?.forEach((logRecord: any) => {
-            const timestamp = new Date(parseInt(logRecord.timeUnixNano) / 1000000);
-            
-            const logAttrs: Record<string, string> = {};
-            logRecord.attributes?.forEach((attr: any) => {
-              if (attr.value?.stringValue !== undefined) {
-                logAttrs[attr.key] = attr.value.stringValue;
-              } else if (attr.value?.intValue !== undefined) {
-                logAttrs[attr.key] = attr.value.intValue.toString();
-              }
-            });
-
-            const logRecord_clean = {
-              Timestamp: timestamp.toISOString().replace('T', ' ').replace('Z', ''),
-              TraceId: logRecord.traceId || '',
-              SpanId: logRecord.spanId || '',
-              TraceFlags: 0,
-              SeverityText: logRecord.severityText || 'INFO',
-              SeverityNumber: logRecord.severityNumber || 9,
-              ServiceName: resourceAttrs['service.name'] || serviceName,
-              Body: logRecord.body?.stringValue || '',
-              ResourceSchemaUrl: '',
-              ResourceAttributes: resourceAttrs,
-              ScopeSchemaUrl: '',
-              ScopeName: scopeLog.scope?.name || '',
-              ScopeVersion: scopeLog.scope?.version || '',
-              ScopeAttributes: {},
-              LogAttributes: logAttrs
-            };
-
-            logData.push(logRecord_clean);
-          });
-        });
-      });
-    }
+    const batch = otlpTransformer.transformLogs(logs);
+    const logData: LogData[] = batch.logs;
+
+    reportBatch('logs', batch);
 
-    // Insert logs into ClickHouse
+    // Write logs to the configured sink
     if (logData.length > 0) {
-      await clickHouseService.insertLogs(logData);
+      await telemetrySink.writeLogs(logData);
+    }
+    if (batch.events.length > 0) {
+      await telemetrySink.writeEvents(batch.events);
     }
 
+    ingestionErrorRate.record(false);
     res.status(200).json({ success: true });
   } catch (error) {
-    logger.error('Failed to process OTEL logs', { error });
-    res.status(500).json({ error: 'Failed to process logs' });
+    logThrottledError('otel-process-logs', 'Failed to process OTEL logs', { error });
+    recentErrors.record('otel-ingestion', 'logs', error);
+    ingestionErrorRate.recordFailure(error);
+    sendIngestionError(res, 'logs', error);
   }
 });
 
@@ -401,13 +323,145 @@ router.get('/logs', async (req: Request, res: Response) => {
   }
 });
 
+const signalsResponse = (signals: Record<Signal, boolean>) => ({
+  signals,
+  disabledPolicy: DISABLED_SIGNAL_POLICY,
+  scope: signalToggles.scope,
+  instanceId: INSTANCE_NAME,
+});
+
+// Current per-signal write toggles
+router.get('/admin/signals', authenticateToken, requireAdmin, (req: Request, res: Response) => {
+  res.status(200).json(signalsResponse(signalToggles.current()));
+});
+
+// Enable or disable writes per signal, e.g. { "logs": false }
+router.put('/admin/signals', authenticateToken, requireAdmin, async (req: Request, res: Response) => {
+  try {
+    const signals = await signalToggles.update(req.body || {});
+
+    logger.warn('OTEL signal writes updated', { signals, scope: signalToggles.scope, userId: req.user?.userId });
+    res.status(200).json(signalsResponse(signals));
+  } catch (error) {
+    logger.error('Failed to update OTEL signal writes', { error });
+    res.status(500).json({ error: 'Failed to update signal writes' });
+  }
+});
+
+// Recent ingestion errors for debugging without tailing pod logs (admin only, messages are raw)
+router.get('/debug/errors', authenticateToken, requireAdmin, (req: Request, res: Response) => {
+  res.status(200).json({ errors: recentErrors.list() });
+});
+
+// Seconds since each service last sent telemetry to this instance
+router.get('/services/last-seen', (req: Request, res: Response) => {
+  const now = Date.now();
+  const services = serviceTracker.list(now).map(({ serviceName, lastSeen, secondsSinceLastSeen }) => ({
+    serviceName,
+    lastSeen: new Date(lastSeen).toISOString(),
+    secondsSinceLastSeen,
+  }));
+
+  res.status(200).json({
+    services,
+    distinctServices: serviceTracker.countDistinct(now),
+    windowMs: DISTINCT_SERVICES_WINDOW_MS,
+    capped: serviceTracker.capped,
+  });
+});
+
+// Only reported once a write has succeeded, so a pod that never writes shows no value
+meter
+  .createObservableGauge(meter.namespaced('time_to_first_write_seconds'), {
+    description: 'Seconds from process start to the first successful ClickHouse insert',
+  })
+  .addCallback((result) => {
+    const seconds = clickHouseService.getTimeToFirstWriteSeconds();
+    if (seconds !== undefined) {
+      result.observe(seconds);
+    }
+  });
+
+meter
+  .createObservableGauge(meter.namespaced('inflight_inserts'), {
+    description: 'ClickHouse inserts currently holding a write slot',
+  })
+  .addCallback((result) => result.observe(clickHouseService.getInFlightInserts()));
+
+meter
+  .createObservableGauge(meter.namespaced('queued_inserts'), {
+    description: 'ClickHouse inserts waiting for a free write slot',
+  })
+  .addCallback((result) => result.observe(clickHouseService.getQueuedInserts()));
+
+const CIRCUIT_STATE_VALUES: Record<CircuitState, number> = { closed: 0, 'half-open': 1, open: 2 };
+
+meter
+  .createObservableGauge(meter.namespaced('write_circuit_state'), {
+    description: 'ClickHouse write circuit breaker state (0 = closed, 1 = half-open, 2 = open)',
+  })
+  .addCallback((result) => result.observe(CIRCUIT_STATE_VALUES[clickHouseService.getWriteCircuitState()]));
+
+meter
+  .createObservableGauge(meter.namespaced('ingestion_error_rate'), {
+    description: 'Share of failed OTLP ingestion requests over the rolling window',
+  })
+  .addCallback((result) => result.observe(ingestionErrorRate.snapshot().rate));
+
+meter
+  .createObservableGauge(meter.namespaced('distinct_services'), {
+    description: 'Distinct service names seen within the distinct-services window',
+  })
+  .addCallback((result) => result.observe(serviceTracker.countDistinct()));
+
+// Lets alerts fire per service when one stops sending, without polling /services/last-seen
+meter
+  .createObservableGauge(meter.namespaced('service_seconds_since_last_seen'), {
+    description: 'Seconds since each service last sent telemetry to this instance',
+    unit: 's',
+  })
+  .addCallback((result) => {
+    serviceTracker.list().forEach(({ serviceName, secondsSinceLastSeen }) => {
+      result.observe(secondsSinceLastSeen, { service: serviceName });
+    });
+  });
+
+// Degrade readiness once enough requests in the window fail, so bad pods leave rotation
+const MAX_ERROR_RATE = parseFloat(process.env.OTEL_HEALTH_MAX_ERROR_RATE || '0.5');
+const MIN_ERROR_RATE_SAMPLES = parseInt(process.env.OTEL_HEALTH_MIN_SAMPLES || '10');
+
 // Health check endpoint for OTEL collector
 router.get('/health', (req: Request, res: Response) => {
-  res.status(200).json({
-    status: 'healthy',
+  const readiness = ingestionErrorRate.readiness(MAX_ERROR_RATE, MIN_ERROR_RATE_SAMPLES);
+
+  res.status(readiness.statusCode).json({
+    status: readiness.status,
+    errorRate: readiness.rate,
     timestamp: new Date().toISOString(),
     service: 'appsentry-otel-ingestion',
+    inFlightInserts: clickHouseService.getInFlightInserts(),
+    queuedInserts: clickHouseService.getQueuedInserts(),
+    writeCircuit: clickHouseService.getWriteCircuitState(),
+    distinctServices: serviceTracker.countDistinct(),
   });
 });
 
+// Resolved ingestion settings, including defaults, for the admin /api/config endpoint
+export const ingestionSettings = () => {
+  const transform = otlpTransformer.getConfig();
+  return {
+    disabledSignalPolicy: DISABLED_SIGNAL_POLICY,
+    signalTogglesScope: signalToggles.scope,
+    ...transform,
+    metricDropRules: transform.metricDropRules.map((rule) => ({ name: rule.name?.source, label: rule.label })),
+    geoIp: geoIpConfig,
+    maxTrackedServices: MAX_TRACKED_SERVICES,
+    distinctServicesWindowMs: DISTINCT_SERVICES_WINDOW_MS,
+    healthMaxErrorRate: MAX_ERROR_RATE,
+    healthMinSamples: MIN_ERROR_RATE_SAMPLES,
+    errorRateWindowMs: ERROR_RATE_WINDOW_MS,
+    recentErrorsCapacity: parseInt(process.env.RECENT_ERRORS_CAPACITY || '100'),
+  };
+};
+
 export default router;
\ No newline at end of file
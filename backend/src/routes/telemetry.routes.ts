@@ -1,9 +1,10 @@
 import { Router, Request, Response } from 'express';
 import { logger } from '../utils/logger';
-import { metrics, trace } from '@opentelemetry/api';
+import { trace } from '@opentelemetry/api';
+import { AppMeter } from '../utils/metrics';
 
 const router = Router();
-const meter = metrics.getMeter('appsentry-test', '1.0.0');
+const meter = new AppMeter('appsentry-test', '1.0.0');
 const tracer = trace.getTracer('appsentry-test', '1.0.0');
 
 // Test metrics
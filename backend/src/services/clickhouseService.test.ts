@@ -0,0 +1,288 @@
+import { describe, it } from 'node:test';
+import assert from 'node:assert/strict';
+import { ClickHouseClient, ClickHouseError } from '@clickhouse/client';
+import {
+  ClickHouseService,
+  InsertQueueFullError,
+  InsertTimeoutError,
+  isRetryableWriteError,
+  loadClickHouseConfig,
+} from './clickhouseService';
+
+// Rejects inserts above maxRows the way ClickHouse reports an insert over its memory limit
+const stubClient = (maxRows: number) => {
+  const accepted: number[] = [];
+  const client = {
+    query: async () => ({ json: async () => [] }),
+    insert: async ({ values }: { values: unknown[] }) => {
+      if (values.length > maxRows) {
+        throw new ClickHouseError({
+          message: 'Memory limit (for query) exceeded',
+          code: '241',
+          type: 'MEMORY_LIMIT_EXCEEDED',
+        });
+      }
+      accepted.push(values.length);
+      return {};
+    },
+  };
+  return { client: client as unknown as ClickHouseClient, accepted };
+};
+
+// Accepts every insert and keeps its arguments
+const recordingClient = (columns: Array<{ name: string }> = []) => {
+  const inserts: Array<{ table: string; values: any[]; clickhouse_settings: Record<string, unknown> }> = [];
+  const queries: string[] = [];
+  const client = {
+    query: async ({ query }: { query: string }) => {
+      queries.push(query);
+      return { json: async () => columns };
+    },
+    insert: async (params: (typeof inserts)[number]) => {
+      inserts.push(params);
+      return {};
+    },
+  };
+  return { client: client as unknown as ClickHouseClient, inserts, queries };
+};
+
+const rows = (count: number) => Array.from({ length: count }, (_, index) => ({ SpanId: index.toString() }));
+
+describe('ClickHouseService inserts', () => {
+  it('splits batches ClickHouse rejects as too large until they fit', async () => {
+    const { client, accepted } = stubClient(300);
+    const service = new ClickHouseService({ ...loadClickHouseConfig({}), minSplitRows: 100 }, client);
+
+    await service.insertTraces(rows(1000));
+    assert.deepEqual(accepted, [250, 250, 250, 250]);
+    assert.equal(service.getWriteCircuitState(), 'closed');
+  });
+
+  it('gives up once a half would fall below minSplitRows', async () => {
+    const { client, accepted } = stubClient(50);
+    const service = new ClickHouseService({ ...loadClickHouseConfig({}), minSplitRows: 100 }, client);
+
+    await assert.rejects(service.insertTraces(rows(300)), ClickHouseError);
+    assert.deepEqual(accepted, []);
+  });
+});
+
+describe('ClickHouseService write timeout', () => {
+  it('fails a hung insert with a retryable timeout error', async () => {
+    // Never resolves on its own, only when the insert is aborted
+    const client = {
+      query: async () => ({ json: async () => [] }),
+      insert: ({ abort_signal }: { abort_signal: AbortSignal }) =>
+        new Promise((_, reject) => abort_signal.addEventListener('abort', () => reject(new Error('aborted')))),
+    } as unknown as ClickHouseClient;
+    const service = new ClickHouseService({ ...loadClickHouseConfig({}), writeTimeoutMs: 20 }, client);
+
+    const error = await service.insertTraces(rows(1)).catch((caught) => caught);
+    assert.ok(error instanceof InsertTimeoutError);
+    assert.ok(isRetryableWriteError(error));
+  });
+});
+
+describe('ClickHouseService self-test', () => {
+  it('fails with the table name when the sentinel write is rejected', async () => {
+    const deleted: string[] = [];
+    const client = {
+      query: async () => ({ json: async () => [{ count: '1' }] }),
+      insert: async () => {
+        throw new Error('Not enough privileges');
+      },
+      command: async ({ query }: { query: string }) => {
+        deleted.push(query);
+        return {};
+      },
+    } as unknown as ClickHouseClient;
+    const service = new ClickHouseService(loadClickHouseConfig({}), client);
+
+    await assert.rejects(service.selfTest(), /self-test failed for otel.traces: Not enough privileges/);
+    assert.equal(deleted.length, 1);
+  });
+});
+
+describe('ClickHouseService max_execution_time', () => {
+  it('applies each signal its own setting', async () => {
+    const { client, inserts } = recordingClient();
+    const config = loadClickHouseConfig({
+      CLICKHOUSE_TRACES_MAX_EXECUTION_TIME: '5',
+      CLICKHOUSE_LOGS_MAX_EXECUTION_TIME: '300',
+    });
+    const service = new ClickHouseService(config, client);
+
+    await service.insertTraces(rows(1));
+    await service.insertMetrics(rows(1));
+    await service.insertLogs(rows(1));
+    assert.deepEqual(
+      inserts.map((insert) => [insert.table, insert.clickhouse_settings.max_execution_time]),
+      [
+        ['otel.traces', 5],
+        ['otel.metrics_sum', undefined],
+        ['otel.logs', 300],
+      ],
+    );
+  });
+});
+
+describe('ClickHouseService cluster tables', () => {
+  it('writes to the Distributed table when a cluster is configured', async () => {
+    const { client, inserts } = recordingClient();
+    const service = new ClickHouseService(
+      loadClickHouseConfig({ CLICKHOUSE_CLUSTER: 'appsentry', CLICKHOUSE_DISTRIBUTED_SUFFIX: '_dist' }),
+      client,
+    );
+
+    await service.insertTraces(rows(1));
+    assert.equal(inserts[0].table, 'otel.traces_dist');
+  });
+
+  it('writes to the configured table directly without a cluster', async () => {
+    const { client, inserts } = recordingClient();
+    await new ClickHouseService(loadClickHouseConfig({}), client).insertTraces(rows(1));
+    assert.equal(inserts[0].table, 'otel.traces');
+  });
+});
+
+describe('ClickHouseService environment tables', () => {
+  it('routes records by deployment.environment to the listed environments only', async () => {
+    const { client, inserts } = recordingClient();
+    const service = new ClickHouseService(loadClickHouseConfig({ CLICKHOUSE_ENVIRONMENT_TABLES: 'staging' }), client);
+    const record = (environment: string) => ({ ResourceAttributes: { 'deployment.environment': environment } });
+
+    await service.insertLogs([record('staging'), record('production'), record('staging')]);
+    assert.deepEqual(
+      inserts.map((insert) => [insert.table, insert.values.length]),
+      [
+        ['otel.logs_staging', 2],
+        ['otel.logs', 1],
+      ],
+    );
+  });
+});
+
+describe('ClickHouseService attribute columns', () => {
+  const span = { SpanId: '1', SpanAttributes: { 'http.route': '/' } };
+
+  it('passes attribute maps through for Map columns', async () => {
+    const { client, inserts, queries } = recordingClient();
+    const service = new ClickHouseService(loadClickHouseConfig({}), client);
+
+    await service.insertTraces([span]);
+    await service.insertTraces([span]);
+    assert.deepEqual(inserts[0].values[0].SpanAttributes, { 'http.route': '/' });
+    assert.equal(queries.length, 1);
+  });
+
+  it('JSON-encodes attribute maps for String columns', async () => {
+    const { client, inserts } = recordingClient([{ name: 'SpanAttributes' }]);
+    await new ClickHouseService(loadClickHouseConfig({}), client).insertTraces([span]);
+    assert.equal(inserts[0].values[0].SpanAttributes, '{"http.route":"/"}');
+  });
+});
+
+describe('ClickHouseService insert deduplication', () => {
+  it('sends the same token for identical batches when enabled', async () => {
+    const { client, inserts } = recordingClient();
+    const service = new ClickHouseService(loadClickHouseConfig({ CLICKHOUSE_INSERT_DEDUPLICATION: 'true' }), client);
+
+    await service.insertTraces(rows(3));
+    await service.insertTraces(rows(3));
+    await service.insertTraces(rows(2));
+    const tokens = inserts.map((insert) => insert.clickhouse_settings.insert_deduplication_token);
+    assert.match(String(tokens[0]), /^[0-9a-f]{64}$/);
+    assert.equal(tokens[1], tokens[0]);
+    assert.notEqual(tokens[2], tokens[0]);
+  });
+
+  it('sends no token by default', async () => {
+    const { client, inserts } = recordingClient();
+    await new ClickHouseService(loadClickHouseConfig({}), client).insertTraces(rows(1));
+    assert.equal(inserts[0].clickhouse_settings.insert_deduplication_token, undefined);
+  });
+});
+
+describe('ClickHouseService startup connection', () => {
+  const pingingClient = (availableFromAttempt: number) => {
+    let attempts = 0;
+    const client = {
+      ping: async () => {
+        attempts++;
+        if (attempts < availableFromAttempt) throw new Error('ECONNREFUSED');
+        return { success: true };
+      },
+    };
+    return { client: client as unknown as ClickHouseClient, attempts: () => attempts };
+  };
+
+  it('succeeds once ClickHouse comes up on a later attempt', async () => {
+    const { client, attempts } = pingingClient(3);
+    const service = new ClickHouseService(loadClickHouseConfig({}), client);
+
+    assert.equal(await service.waitForConnection(5, 1), true);
+    assert.equal(attempts(), 3);
+  });
+
+  it('gives up after the configured attempts', async () => {
+    const { client, attempts } = pingingClient(10);
+    const service = new ClickHouseService(loadClickHouseConfig({}), client);
+
+    assert.equal(await service.waitForConnection(2, 1), false);
+    assert.equal(attempts(), 2);
+  });
+});
+
+describe('loadClickHouseConfig connection pooling', () => {
+  it('reads keep-alive and pool settings from the environment', () => {
+    const config = loadClickHouseConfig({
+      CLICKHOUSE_KEEP_ALIVE: 'false',
+      CLICKHOUSE_IDLE_SOCKET_TTL_MS: '1000',
+      CLICKHOUSE_MAX_OPEN_CONNECTIONS: '32',
+      CLICKHOUSE_COMPRESS_REQUESTS: 'true',
+    });
+    assert.equal(config.keepAlive, false);
+    assert.equal(config.idleSocketTtlMs, 1000);
+    assert.equal(config.maxOpenConnections, 32);
+    assert.equal(config.compressRequests, true);
+  });
+
+  it('keeps sockets alive with a short idle TTL by default', () => {
+    const config = loadClickHouseConfig({});
+    assert.equal(config.keepAlive, true);
+    assert.equal(config.idleSocketTtlMs, 2500);
+    assert.equal(config.maxOpenConnections, 10);
+    assert.equal(config.compressRequests, false);
+  });
+});
+
+describe('ClickHouseService insert queue', () => {
+  it('refuses new inserts once maxQueuedInserts are waiting for a slot', async () => {
+    const pending: Array<() => void> = [];
+    const client = {
+      query: async () => ({ json: async () => [] }),
+      insert: () => new Promise((resolve) => pending.push(() => resolve({}))),
+    } as unknown as ClickHouseClient;
+    const service = new ClickHouseService(
+      { ...loadClickHouseConfig({}), maxConcurrentInserts: 1, maxQueuedInserts: 1, writeTimeoutMs: 0 },
+      client,
+    );
+
+    const running = service.insertTraces(rows(1));
+    const queued = service.insertTraces(rows(1));
+    await new Promise((resolve) => setImmediate(resolve));
+    assert.equal(service.getInFlightInserts(), 1);
+    assert.equal(service.getQueuedInserts(), 1);
+
+    const error = await service.insertTraces(rows(1)).catch((caught) => caught);
+    assert.ok(error instanceof InsertQueueFullError);
+    assert.ok(isRetryableWriteError(error));
+
+    pending.shift()!();
+    await running;
+    await new Promise((resolve) => setImmediate(resolve));
+    pending.shift()!();
+    await queued;
+    assert.equal(service.getQueuedInserts(), 0);
+  });
+});
@@ -1,14 +1,119 @@
-import { createClient, ClickHouseClient } from '@clickhouse/client';
+import { createHash, randomBytes } from 'crypto';
+import { createClient, ClickHouseClient, ClickHouseError, ClickHouseSettings } from '@clickhouse/client';
+import { Histogram } from '@opentelemetry/api';
 import { logger } from '../utils/logger';
+import { AppMeter } from '../utils/metrics';
+import { logThrottledError } from '../utils/throttledLogger';
+import { Semaphore, SemaphoreTimeoutError } from '../utils/semaphore';
+import { CircuitBreaker, CircuitOpenError, CircuitState } from '../utils/circuitBreaker';
 
-interface ClickHouseConfig {
+export interface ClickHouseConfig {
   host: string;
   port: number;
   database: string;
   username?: string;
   password?: string;
+  writeTimeoutMs: number;
+  maxConcurrentInserts: number;
+  maxQueuedInserts: number;
+  breakerFailureThreshold: number;
+  breakerCooldownMs: number;
+  minSplitRows: number;
+  insertDeduplication: boolean;
+  cluster?: string;
+  distributedSuffix: string;
+  tables: {
+    traces: string;
+    metrics: string;
+    logs: string;
+    events: string;
+    gauges: string;
+  };
+  environmentTables: string[];
+  maxExecutionTime: {
+    traces?: number;
+    metrics?: number;
+    logs?: number;
+  };
+  keepAlive: boolean;
+  idleSocketTtlMs: number;
+  maxOpenConnections: number;
+  compressRequests: boolean;
 }
 
+// Byte-scale buckets from 1 KiB to 64 MiB; the SDK's default boundaries top out at 10000
+const BATCH_BYTES_BUCKETS = [1024, 4096, 16384, 65536, 262144, 1048576, 4194304, 16777216, 67108864];
+
+// Created on first use: this module is imported before otel.ts registers the global
+// MeterProvider, and instruments from a meter obtained earlier are no-ops
+let batchBytes: Histogram | undefined;
+
+const recordBatchBytes = (table: string, bytes: number): void => {
+  if (!batchBytes) {
+    const meter = new AppMeter('appsentry-otel-ingestion', '1.0.0');
+    batchBytes = meter.createHistogram(meter.namespaced('batch_bytes'), {
+      description: 'Approximate serialized size of each ClickHouse insert batch in bytes',
+      unit: 'By',
+      advice: { explicitBucketBoundaries: BATCH_BYTES_BUCKETS },
+    });
+  }
+  batchBytes.record(bytes, { table });
+};
+
+// Serializing a whole batch just to measure it doubles the JSON work on large inserts, so
+// the size is extrapolated from the first rows
+const SIZE_SAMPLE_ROWS = 20;
+
+const estimateBatchBytes = (rows: any[]): number => {
+  const sample = rows.slice(0, SIZE_SAMPLE_ROWS);
+  if (sample.length === 0) return 0;
+  return Math.round((Buffer.byteLength(JSON.stringify(sample)) * rows.length) / sample.length);
+};
+
+// ClickHouse error codes for a single insert that is too big to process. The client puts
+// the numeric code in error.code and its name in error.type.
+const BATCH_TOO_LARGE_CODES: Record<string, string> = {
+  '241': 'MEMORY_LIMIT_EXCEEDED',
+};
+
+const isBatchTooLarge = (error: unknown): boolean =>
+  error instanceof ClickHouseError &&
+  (error.code in BATCH_TOO_LARGE_CODES || Object.values(BATCH_TOO_LARGE_CODES).includes(error.type ?? ''));
+
+const optionalInt = (value?: string): number | undefined => (value ? parseInt(value) : undefined);
+
+// Timeouts of 0 or less disable the timeout; unparseable values fall back to the default
+const timeoutMs = (env: NodeJS.ProcessEnv, name: string, fallback: number): number => {
+  const raw = env[name];
+  if (raw === undefined || raw === '') return fallback;
+
+  const value = parseInt(raw);
+  if (Number.isNaN(value)) {
+    logger.warn(`Ignoring invalid ${name}="${raw}", using ${fallback}ms`);
+    return fallback;
+  }
+  return Math.max(0, value);
+};
+
+export class InsertTimeoutError extends Error {
+  constructor(table: string, timeoutMs: number) {
+    super(`Insert into ${table} timed out after ${timeoutMs}ms`);
+    this.name = 'InsertTimeoutError';
+  }
+}
+
+export class InsertQueueFullError extends Error {
+  constructor(table: string, queued: number) {
+    super(`Insert into ${table} refused: ${queued} inserts already queued`);
+    this.name = 'InsertQueueFullError';
+  }
+}
+
+// Write failures caused by ClickHouse being slow or unavailable rather than by the data,
+// which a client can retry later
+export const isRetryableWriteError = (error: unknown): boolean =>
+  error instanceof CircuitOpenError || error instanceof InsertTimeoutError || error instanceof InsertQueueFullError;
+
 interface TraceData {
   timestamp: string;
   trace_id: string;
@@ -49,30 +154,92 @@ interface LogData {
   log_attributes: Record<string, string>;
 }
 
+// Settings from the CLICKHOUSE_* environment variables, with their defaults
+export const loadClickHouseConfig = (env: NodeJS.ProcessEnv = process.env): ClickHouseConfig => ({
+  host: env.CLICKHOUSE_HOST || 'localhost',
+  port: parseInt(env.CLICKHOUSE_PORT || '8123'),
+  database: env.CLICKHOUSE_DATABASE || 'otel',
+  username: env.CLICKHOUSE_USERNAME || 'default',
+  password: env.CLICKHOUSE_PASSWORD || '',
+  writeTimeoutMs: timeoutMs(env, 'CLICKHOUSE_WRITE_TIMEOUT_MS', 30000),
+  maxConcurrentInserts: parseInt(env.CLICKHOUSE_MAX_CONCURRENT_INSERTS || '4'),
+  maxQueuedInserts: parseInt(env.CLICKHOUSE_MAX_QUEUED_INSERTS || '0'),
+  breakerFailureThreshold: parseInt(env.CLICKHOUSE_BREAKER_FAILURE_THRESHOLD || '5'),
+  breakerCooldownMs: parseInt(env.CLICKHOUSE_BREAKER_COOLDOWN_MS || '30000'),
+  minSplitRows: parseInt(env.CLICKHOUSE_MIN_SPLIT_ROWS || '100'),
+  insertDeduplication: env.CLICKHOUSE_INSERT_DEDUPLICATION === 'true',
+  cluster: env.CLICKHOUSE_CLUSTER || undefined,
+  distributedSuffix: env.CLICKHOUSE_DISTRIBUTED_SUFFIX || '_distributed',
+  tables: {
+    traces: env.CLICKHOUSE_TRACES_TABLE || 'otel.traces',
+    metrics: env.CLICKHOUSE_METRICS_TABLE || 'otel.metrics_sum',
+    logs: env.CLICKHOUSE_LOGS_TABLE || 'otel.logs',
+    events: env.CLICKHOUSE_EVENTS_TABLE || 'otel.events',
+    // Read by the query API only; the OTLP receiver doesn't write gauges
+    gauges: env.CLICKHOUSE_GAUGES_TABLE || 'otel.metrics_gauge',
+  },
+  environmentTables: (env.CLICKHOUSE_ENVIRONMENT_TABLES || '')
+    .split(',')
+    .map((environment) => environment.trim())
+    .filter(Boolean),
+  maxExecutionTime: {
+    traces: optionalInt(env.CLICKHOUSE_TRACES_MAX_EXECUTION_TIME),
+    metrics: optionalInt(env.CLICKHOUSE_METRICS_MAX_EXECUTION_TIME),
+    logs: optionalInt(env.CLICKHOUSE_LOGS_MAX_EXECUTION_TIME),
+  },
+  // Keep idle sockets shorter than any load balancer idle timeout so dead ones aren't reused
+  keepAlive: env.CLICKHOUSE_KEEP_ALIVE !== 'false',
+  idleSocketTtlMs: parseInt(env.CLICKHOUSE_IDLE_SOCKET_TTL_MS || '2500'),
+  maxOpenConnections: parseInt(env.CLICKHOUSE_MAX_OPEN_CONNECTIONS || '10'),
+  compressRequests: env.CLICKHOUSE_COMPRESS_REQUESTS === 'true',
+});
+
 class ClickHouseService {
   private client: ClickHouseClient;
   private config: ClickHouseConfig;
-
-  constructor() {
-    this.config = {
-      host: process.env.CLICKHOUSE_HOST || 'localhost',
-      port: parseInt(process.env.CLICKHOUSE_PORT || '8123'),
-      database: process.env.CLICKHOUSE_DATABASE || 'otel',
-      username: process.env.CLICKHOUSE_USERNAME || 'default',
-      password: process.env.CLICKHOUSE_PASSWORD || '',
-    };
-
-    this.client = createClient({
+  private insertSlots: Semaphore;
+  private writeBreaker: CircuitBreaker;
+  private stringColumns = new Map<string, Promise<Set<string>>>();
+  private firstWriteSeconds?: number;
+
+  constructor(config: ClickHouseConfig = loadClickHouseConfig(), client?: ClickHouseClient) {
+    this.config = config;
+
+    this.insertSlots = new Semaphore(this.config.maxConcurrentInserts);
+    this.writeBreaker = new CircuitBreaker(
+      'clickhouse-writes',
+      this.config.breakerFailureThreshold,
+      this.config.breakerCooldownMs,
+      // Oversized batches are split and retried, they don't mean ClickHouse is unhealthy
+      (error) => !isBatchTooLarge(error),
+    );
+
+    this.client = client ?? createClient({
       url: `http://${this.config.host}:${this.config.port}`,
       database: this.config.database,
       username: this.config.username,
       password: this.config.password,
+      keep_alive: {
+        enabled: this.config.keepAlive,
+        idle_socket_ttl: this.config.idleSocketTtlMs,
+      },
+      max_open_connections: this.config.maxOpenConnections,
+      compression: {
+        request: this.config.compressRequests,
+      },
     });
 
     logger.info('ClickHouse service initialized', {
       host: this.config.host,
       port: this.config.port,
       database: this.config.database,
+      writeTimeoutMs: this.config.writeTimeoutMs,
+      maxConcurrentInserts: this.config.maxConcurrentInserts,
+      cluster: this.config.cluster,
+      tables: this.config.tables,
+      keepAlive: this.config.keepAlive,
+      idleSocketTtlMs: this.config.idleSocketTtlMs,
+      maxOpenConnections: this.config.maxOpenConnections,
     });
   }
 
@@ -86,20 +253,32 @@ class ClickHouseService {
     }
   }
 
+  // Retry the initial ping with exponential backoff, since ClickHouse may still be starting
+  async waitForConnection(
+    attempts = parseInt(process.env.CLICKHOUSE_CONNECT_ATTEMPTS || '5'),
+    backoffMs = parseInt(process.env.CLICKHOUSE_CONNECT_BACKOFF_MS || '1000'),
+  ): Promise<boolean> {
+    for (let attempt = 1; attempt <= attempts; attempt++) {
+      if (await this.ping()) return true;
+      if (attempt === attempts) break;
+
+      const delay = backoffMs * 2 ** (attempt - 1);
+      logger.info(`ClickHouse not reachable, retrying in ${delay}ms (attempt ${attempt}/${attempts})`);
+      await new Promise((resolve) => setTimeout(resolve, delay));
+    }
+    return false;
+  }
+
   async insertTraces(traces: any[]): Promise<void> {
     try {
       if (traces.length === 0) return;
 
 
-      await this.client.insert({
-        table: 'otel.traces',
-        values: traces,
-        format: 'JSONEachRow',
-      });
+      await this.insertRouted(this.config.tables.traces, traces, this.config.maxExecutionTime.traces);
 
       logger.debug(`Inserted ${traces.length} traces into ClickHouse`);
     } catch (error) {
-      logger.error('Failed to insert traces into ClickHouse', { error });
+      logThrottledError('clickhouse-insert-traces', 'Failed to insert traces into ClickHouse', { error });
       throw error;
     }
   }
@@ -109,16 +288,12 @@ class ClickHouseService {
       if (metrics.length === 0) return;
 
 
-      // Insert into the correct metrics table (defaulting to metrics_sum for now)
-      await this.client.insert({
-        table: 'otel.metrics_sum',
-        values: metrics,
-        format: 'JSONEachRow',
-      });
+      // Insert into the configured metrics table (metrics_sum unless overridden)
+      await this.insertRouted(this.config.tables.metrics, metrics, this.config.maxExecutionTime.metrics);
 
       logger.debug(`Inserted ${metrics.length} metrics into ClickHouse`);
     } catch (error) {
-      logger.error('Failed to insert metrics into ClickHouse', { error });
+      logThrottledError('clickhouse-insert-metrics', 'Failed to insert metrics into ClickHouse', { error });
       throw error;
     }
   }
@@ -127,19 +302,277 @@ class ClickHouseService {
     try {
       if (logs.length === 0) return;
 
-      await this.client.insert({
-        table: 'otel.logs',
-        values: logs,
+      await this.insertRouted(this.config.tables.logs, logs, this.config.maxExecutionTime.logs);
+
+      logger.debug(`Inserted ${logs.length} logs into ClickHouse`);
+    } catch (error) {
+      logThrottledError('clickhouse-insert-logs', 'Failed to insert logs into ClickHouse', { error });
+      throw error;
+    }
+  }
+
+  // Structured business events share the logs schema but live in their own table
+  async insertEvents(events: any[]): Promise<void> {
+    try {
+      if (events.length === 0) return;
+
+      await this.insertRouted(this.config.tables.events, events, this.config.maxExecutionTime.logs);
+
+      logger.debug(`Inserted ${events.length} events into ClickHouse`);
+    } catch (error) {
+      logThrottledError('clickhouse-insert-events', 'Failed to insert events into ClickHouse', { error });
+      throw error;
+    }
+  }
+
+  getConfig(): ClickHouseConfig {
+    return { ...this.config };
+  }
+
+  // Seconds from process start to the first successful insert, undefined until then
+  getTimeToFirstWriteSeconds(): number | undefined {
+    return this.firstWriteSeconds;
+  }
+
+  getInFlightInserts(): number {
+    return this.insertSlots.inFlight;
+  }
+
+  getQueuedInserts(): number {
+    return this.insertSlots.waiting;
+  }
+
+  getWriteCircuitState(): CircuitState {
+    return this.writeBreaker.getState();
+  }
+
+  // On a replicated cluster, reads and writes go to the Distributed table fronting each local table
+  private clusterTable(table: string): string {
+    return this.config.cluster ? `${table}${this.config.distributedSuffix}` : table;
+  }
+
+  // Records from environments listed in environmentTables go to <table>_<environment>,
+  // everything else to the base table
+  private async insertRouted(table: string, records: any[], maxExecutionTime?: number): Promise<void> {
+    const byTable = new Map<string, any[]>();
+
+    records.forEach((record) => {
+      const environment = record.ResourceAttributes?.['deployment.environment'];
+      const target = this.config.environmentTables.includes(environment) ? `${table}_${environment}` : table;
+      const rows = byTable.get(target) || [];
+      rows.push(record);
+      byTable.set(target, rows);
+    });
+
+    for (const [target, rows] of byTable) {
+      await this.insert(this.clusterTable(target), rows, maxExecutionTime);
+    }
+  }
+
+  // Writes fail fast while the breaker is open, and inserts beyond maxConcurrentInserts
+  // wait for a slot, holding the OTLP request open as backpressure. Batches rejected as
+  // too large are retried in halves down to minSplitRows. Once maxQueuedInserts are already
+  // waiting, new inserts are refused instead of piling up open batches in memory.
+  private async insert(table: string, values: any[], maxExecutionTime?: number): Promise<void> {
+    if (this.config.maxQueuedInserts > 0 && this.insertSlots.waiting >= this.config.maxQueuedInserts) {
+      throw new InsertQueueFullError(table, this.insertSlots.waiting);
+    }
+
+    try {
+      await this.writeBreaker.run(() => this.insertInSlot(table, values, maxExecutionTime));
+      if (this.firstWriteSeconds === undefined) {
+        this.firstWriteSeconds = process.uptime();
+        logger.info(`First ClickHouse write succeeded ${this.firstWriteSeconds.toFixed(1)}s after start`);
+      }
+    } catch (error) {
+      if (!isBatchTooLarge(error) || values.length < this.config.minSplitRows * 2) {
+        throw error;
+      }
+
+      const middle = Math.ceil(values.length / 2);
+      logger.warn(`Insert into ${table} too large, retrying as two batches`, { rows: values.length });
+      await this.insert(table, values.slice(0, middle), maxExecutionTime);
+      await this.insert(table, values.slice(middle), maxExecutionTime);
+    }
+  }
+
+  // Attribute columns may be Map(String, String) or String depending on schema version.
+  // Look up the real column types once per table so objects can be JSON-encoded for String columns.
+  private getStringColumns(table: string): Promise<Set<string>> {
+    let columns = this.stringColumns.get(table);
+    if (!columns) {
+      columns = this.loadStringColumns(table);
+      this.stringColumns.set(table, columns);
+    }
+    return columns;
+  }
+
+  private async loadStringColumns(table: string): Promise<Set<string>> {
+    const [database, name] = table.includes('.') ? table.split('.', 2) : [this.config.database, table];
+
+    try {
+      const result = await this.client.query({
+        query: `SELECT name FROM system.columns WHERE database = {database:String} AND table = {name:String} AND type = 'String'`,
+        query_params: { database, name },
         format: 'JSONEachRow',
       });
+      const rows = await result.json<{ name: string }>();
+      return new Set(rows.map((row) => row.name));
+    } catch (error) {
+      logger.warn(`Could not read column types for ${table}, assuming Map attribute columns`, { error });
+      this.stringColumns.delete(table);
+      return new Set();
+    }
+  }
+
+  private async prepareRows(table: string, values: any[]): Promise<any[]> {
+    const stringColumns = await this.getStringColumns(table);
+    if (stringColumns.size === 0) return values;
+
+    return values.map((row) => {
+      const prepared = { ...row };
+      stringColumns.forEach((column) => {
+        const value = prepared[column];
+        if (value && typeof value === 'object' && !Array.isArray(value)) {
+          prepared[column] = JSON.stringify(value);
+        }
+      });
+      return prepared;
+    });
+  }
 
-      logger.debug(`Inserted ${logs.length} logs into ClickHouse`);
+  // writeTimeoutMs bounds the wait for a slot and the insert together
+  private async insertInSlot(table: string, values: any[], maxExecutionTime?: number): Promise<void> {
+    const startedAt = Date.now();
+    const timeout = this.config.writeTimeoutMs;
+
+    try {
+      await this.insertSlots.run(() => {
+        const remaining = timeout > 0 ? Math.max(1, timeout - (Date.now() - startedAt)) : 0;
+        return this.insertWithTimeout(table, values, remaining, maxExecutionTime);
+      }, timeout);
     } catch (error) {
-      logger.error('Failed to insert logs into ClickHouse', { error });
+      if (error instanceof SemaphoreTimeoutError) {
+        throw new InsertTimeoutError(table, timeout);
+      }
       throw error;
     }
   }
 
+  // Bound each insert so a hung ClickHouse write fails the request instead of blocking it
+  private async insertWithTimeout(
+    table: string,
+    values: any[],
+    timeout: number,
+    maxExecutionTime?: number,
+  ): Promise<void> {
+    const rows = await this.prepareRows(table, values);
+    // The dedup token needs the full serialization anyway, so measure that one exactly
+    const serialized = this.config.insertDeduplication ? JSON.stringify(rows) : undefined;
+    recordBatchBytes(table, serialized ? Buffer.byteLength(serialized) : estimateBatchBytes(rows));
+
+    const settings: ClickHouseSettings = {};
+    if (maxExecutionTime) {
+      settings.max_execution_time = maxExecutionTime;
+    }
+    // Identical retried batches get the same token, so ReplicatedMergeTree drops the duplicate insert
+    if (serialized) {
+      settings.insert_deduplication_token = createHash('sha256').update(serialized).digest('hex');
+    }
+
+    const controller = new AbortController();
+    const timer = timeout > 0 ? setTimeout(() => controller.abort(), timeout) : undefined;
+
+    try {
+      await this.client.insert({
+        table,
+        values: rows,
+        format: 'JSONEachRow',
+        abort_signal: controller.signal,
+        clickhouse_settings: settings,
+      });
+    } catch (error) {
+      if (controller.signal.aborted) {
+        throw new InsertTimeoutError(table, this.config.writeTimeoutMs);
+      }
+      throw error;
+    } finally {
+      if (timer) clearTimeout(timer);
+    }
+  }
+
+  // Write and read back a sentinel row per table to surface schema or permission problems early.
+  // The probe insert is synchronous on Distributed tables so the read-back sees it, and the
+  // sentinel rows are deleted afterwards so they don't show up as a real service.
+  async selfTest(): Promise<void> {
+    const sentinel = `appsentry-self-test-${Date.now()}`;
+    const traceId = randomBytes(16).toString('hex');
+    const now = new Date().toISOString().replace('T', ' ').replace('Z', '');
+    const checks = [
+      {
+        table: this.config.tables.traces,
+        column: 'TraceId',
+        value: traceId,
+        row: { Timestamp: now, TraceId: traceId, SpanName: 'self-test', ServiceName: 'appsentry-self-test' },
+      },
+      {
+        table: this.config.tables.metrics,
+        column: 'MetricName',
+        value: sentinel,
+        row: { TimeUnix: now, StartTimeUnix: now, MetricName: sentinel, Value: 0 },
+      },
+      {
+        table: this.config.tables.logs,
+        column: 'Body',
+        value: sentinel,
+        row: { Timestamp: now, Body: sentinel, ServiceName: 'appsentry-self-test' },
+      },
+    ];
+
+    for (const check of checks) {
+      const table = this.clusterTable(check.table);
+      try {
+        await this.client.insert({
+          table,
+          values: [check.row],
+          format: 'JSONEachRow',
+          clickhouse_settings: { insert_distributed_sync: 1 },
+        });
+
+        const result = await this.client.query({
+          query: `SELECT count() AS count FROM ${table} WHERE ${check.column} = {value:String}`,
+          query_params: { value: check.value },
+          format: 'JSONEachRow',
+        });
+        const rows = await result.json<{ count: string }>();
+
+        if (rows.length === 0 || Number(rows[0].count) === 0) {
+          throw new Error('sentinel row not found after insert');
+        }
+      } catch (error) {
+        const reason = error instanceof Error ? error.message : String(error);
+        throw new Error(`ClickHouse self-test failed for ${table}: ${reason}`);
+      } finally {
+        await this.deleteSentinel(check.table, check.column, check.value);
+      }
+    }
+
+    logger.info('ClickHouse self-test passed');
+  }
+
+  // Lightweight deletes only work on the local tables, so on a cluster run them ON CLUSTER
+  private async deleteSentinel(table: string, column: string, value: string): Promise<void> {
+    const onCluster = this.config.cluster ? ` ON CLUSTER ${this.config.cluster}` : '';
+    try {
+      await this.client.command({
+        query: `DELETE FROM ${table}${onCluster} WHERE ${column} = {value:String}`,
+        query_params: { value },
+      });
+    } catch (error) {
+      logger.warn(`Could not delete self-test row from ${table}`, { error });
+    }
+  }
+
   async getTraces(options: {
     timeRange?: string;
     serviceName?: string;
@@ -173,7 +606,7 @@ class ClickHouseService {
           SpanKind as span_kind,
           ResourceAttributes as resource_attributes,
           SpanAttributes as span_attributes
-        FROM otel.traces 
+        FROM ${this.clusterTable(this.config.tables.traces)}
         ${whereClause}
         ORDER BY Timestamp DESC 
         LIMIT ${limit}
@@ -221,7 +654,7 @@ class ClickHouseService {
           Value as value,
           ResourceAttributes as resource_attributes,
           Attributes as metric_attributes
-        FROM otel.metrics_gauge 
+        FROM ${this.clusterTable(this.config.tables.gauges)}
         ${whereClause}
         
         UNION ALL
@@ -235,7 +668,7 @@ class ClickHouseService {
           Value as value,
           ResourceAttributes as resource_attributes,
           Attributes as metric_attributes
-        FROM otel.metrics_sum 
+        FROM ${this.clusterTable(this.config.tables.metrics)}
         ${whereClause}
         
         ORDER BY timestamp DESC 
@@ -294,7 +727,7 @@ class ClickHouseService {
           ) as service_name,
           ResourceAttributes as resource_attributes,
           LogAttributes as log_attributes
-        FROM otel.logs 
+        FROM ${this.clusterTable(this.config.tables.logs)}
         ${whereClause}
         ORDER BY Timestamp DESC 
         LIMIT ${limit}
@@ -328,7 +761,7 @@ class ClickHouseService {
           SpanKind as span_kind,
           ResourceAttributes as resource_attributes,
           SpanAttributes as span_attributes
-        FROM otel.traces 
+        FROM ${this.clusterTable(this.config.tables.traces)}
         WHERE TraceId = '${traceId}'
         ORDER BY Timestamp ASC
       `;
@@ -356,7 +789,7 @@ class ClickHouseService {
           max(Value) as max_value,
           min(Value) as min_value,
           count() as data_points
-        FROM otel.metrics_gauge 
+        FROM ${this.clusterTable(this.config.tables.gauges)}
         WHERE TimeUnix >= now() - INTERVAL ${this.parseTimeRange(timeRange)}
         GROUP BY service_name, metric_name
         
@@ -370,7 +803,7 @@ class ClickHouseService {
           max(Value) as max_value,
           min(Value) as min_value,
           count() as data_points
-        FROM otel.metrics_sum 
+        FROM ${this.clusterTable(this.config.tables.metrics)}
         WHERE TimeUnix >= now() - INTERVAL ${this.parseTimeRange(timeRange)}
         GROUP BY service_name, metric_name
         
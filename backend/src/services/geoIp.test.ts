@@ -0,0 +1,65 @@
+import { describe, it } from 'node:test';
+import assert from 'node:assert/strict';
+import { AsnResponse, CountryResponse } from 'maxmind';
+import { createGeoIpLookup, loadGeoIpConfig, MaxMindGeoIp } from './geoIp';
+import { loadOtlpTransformConfig, OtlpTransformer } from './otlpTransform';
+
+// 81.2.69.142 is the GB address MaxMind's own test databases use
+const KNOWN_IP = '81.2.69.142';
+
+const reader = <T>(records: Record<string, T>) => ({ get: (address: string) => records[address] || null });
+
+const geoIp = () =>
+  new MaxMindGeoIp(
+    reader({ [KNOWN_IP]: { country: { iso_code: 'GB' } } as CountryResponse }),
+    reader({
+      [KNOWN_IP]: { autonomous_system_number: 20712, autonomous_system_organization: 'Andrews & Arnold Ltd' } as AsnResponse,
+    }),
+  );
+
+describe('MaxMindGeoIp', () => {
+  it('resolves country and ASN for a known IP', () => {
+    assert.deepEqual(geoIp().lookup(KNOWN_IP), {
+      'geo.country.iso_code': 'GB',
+      'client.as.number': '20712',
+      'client.as.organization.name': 'Andrews & Arnold Ltd',
+    });
+  });
+
+  it('returns undefined for unknown or non-IP addresses', () => {
+    assert.equal(geoIp().lookup('10.0.0.1'), undefined);
+    assert.equal(geoIp().lookup('checkout.internal'), undefined);
+  });
+});
+
+describe('createGeoIpLookup', () => {
+  it('is off unless enabled', () => {
+    assert.equal(createGeoIpLookup(loadGeoIpConfig({ OTEL_GEOIP_COUNTRY_DB: '/data/country.mmdb' })), undefined);
+  });
+
+  it('stays off when a database cannot be read', () => {
+    const config = loadGeoIpConfig({ OTEL_GEOIP_ENABLED: 'true', OTEL_GEOIP_COUNTRY_DB: '/nonexistent/country.mmdb' });
+    assert.equal(createGeoIpLookup(config), undefined);
+  });
+});
+
+describe('OtlpTransformer GeoIP enrichment', () => {
+  it('adds geo attributes to spans carrying client.address', () => {
+    const transformer = new OtlpTransformer(loadOtlpTransformConfig({}), geoIp());
+    const span = (address: string) => ({
+      traceId: '5b8efff798038103d269b633813fc60c',
+      spanId: 'eee19b7ec3c1b174',
+      name: 'GET /api/health',
+      startTimeUnixNano: '1700000000000000000',
+      endTimeUnixNano: '1700000000100000000',
+      attributes: [{ key: 'client.address', value: { stringValue: address } }],
+    });
+    const batch = transformer.transformTraces({
+      resourceSpans: [{ resource: { attributes: [] }, scopeSpans: [{ spans: [span(KNOWN_IP), span('10.0.0.1')] }] }],
+    });
+
+    assert.equal(batch.rows[0].SpanAttributes['geo.country.iso_code'], 'GB');
+    assert.equal(batch.rows[0].SpanAttributes['client.as.number'], '20712');
+    assert.deepEqual(batch.rows[1].SpanAttributes, { 'client.address': '10.0.0.1' });
+  });
+});
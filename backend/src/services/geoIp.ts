@@ -0,0 +1,75 @@
+import fs from 'fs';
+import { isIP } from 'net';
+import { AsnResponse, CountryResponse, Reader } from 'maxmind';
+import { logger } from '../utils/logger';
+
+// Adds geo attributes for an IP address, or returns undefined when nothing is known about it
+export interface GeoIpLookup {
+  lookup(address: string): Record<string, string> | undefined;
+}
+
+export interface GeoIpConfig {
+  enabled: boolean;
+  // MaxMind databases, e.g. GeoLite2-Country.mmdb and GeoLite2-ASN.mmdb; either may be left unset
+  countryDbPath: string;
+  asnDbPath: string;
+}
+
+export const loadGeoIpConfig = (env: NodeJS.ProcessEnv = process.env): GeoIpConfig => ({
+  enabled: env.OTEL_GEOIP_ENABLED === 'true',
+  countryDbPath: env.OTEL_GEOIP_COUNTRY_DB || '',
+  asnDbPath: env.OTEL_GEOIP_ASN_DB || '',
+});
+
+// The part of maxmind's Reader used here
+interface LookupReader<T> {
+  get(address: string): T | null;
+}
+
+export class MaxMindGeoIp implements GeoIpLookup {
+  private country?: LookupReader<CountryResponse>;
+  private asn?: LookupReader<AsnResponse>;
+
+  constructor(country?: LookupReader<CountryResponse>, asn?: LookupReader<AsnResponse>) {
+    this.country = country;
+    this.asn = asn;
+  }
+
+  lookup(address: string): Record<string, string> | undefined {
+    if (!isIP(address)) return undefined;
+
+    const attributes: Record<string, string> = {};
+    const country = this.country?.get(address);
+    if (country?.country?.iso_code) {
+      attributes['geo.country.iso_code'] = country.country.iso_code;
+    }
+    const asn = this.asn?.get(address);
+    if (asn?.autonomous_system_number) {
+      attributes['client.as.number'] = asn.autonomous_system_number.toString();
+      if (asn.autonomous_system_organization) {
+        attributes['client.as.organization.name'] = asn.autonomous_system_organization;
+      }
+    }
+    return Object.keys(attributes).length > 0 ? attributes : undefined;
+  }
+}
+
+// Opens the configured databases. Enrichment stays off, with an error logged, when a
+// database can't be read, so a bad path doesn't stop ingestion.
+export const createGeoIpLookup = (config: GeoIpConfig): GeoIpLookup | undefined => {
+  if (!config.enabled) return undefined;
+  if (!config.countryDbPath && !config.asnDbPath) {
+    logger.warn('OTEL_GEOIP_ENABLED is set but neither OTEL_GEOIP_COUNTRY_DB nor OTEL_GEOIP_ASN_DB is');
+    return undefined;
+  }
+
+  try {
+    const open = <T extends CountryResponse | AsnResponse>(path: string) =>
+      path ? new Reader<T>(fs.readFileSync(path)) : undefined;
+
+    return new MaxMindGeoIp(open<CountryResponse>(config.countryDbPath), open<AsnResponse>(config.asnDbPath));
+  } catch (error) {
+    logger.error('Failed to open GeoIP databases, enrichment disabled', { error, config });
+    return undefined;
+  }
+};
@@ -0,0 +1,492 @@
+import { describe, it, mock } from 'node:test';
+import assert from 'node:assert/strict';
+import { ClickHouseClient } from '@clickhouse/client';
+import { loadOtlpTransformConfig, OtlpTransformer } from './otlpTransform';
+import { ClickHouseService, loadClickHouseConfig } from './clickhouseService';
+
+const TRACE_ID = '5b8efff798038103d269b633813fc60c';
+const SPAN_ID = 'eee19b7ec3c1b174';
+
+const transformer = (env: NodeJS.ProcessEnv = {}) => new OtlpTransformer(loadOtlpTransformConfig(env));
+
+const stringAttr = (key: string, value: string) => ({ key, value: { stringValue: value } });
+
+const span = (overrides: Record<string, unknown> = {}) => ({
+  traceId: TRACE_ID,
+  spanId: SPAN_ID,
+  name: 'GET /api/health',
+  kind: 2,
+  startTimeUnixNano: '1700000000123456789',
+  endTimeUnixNano: '1700000000223456789',
+  ...overrides,
+});
+
+const traces = (spans: any[], resource: any[] = [stringAttr('service.name', 'checkout')]) => ({
+  resourceSpans: [{ resource: { attributes: resource }, scopeSpans: [{ scope: { name: 'test' }, spans }] }],
+});
+
+const point = (overrides: Record<string, unknown> = {}) => ({
+  startTimeUnixNano: '1700000000000000000',
+  timeUnixNano: '1700000060000000000',
+  asInt: '5',
+  ...overrides,
+});
+
+const metrics = (metricList: any[], resource: any[] = [stringAttr('service.name', 'checkout')]) => ({
+  resourceMetrics: [{ resource: { attributes: resource }, scopeMetrics: [{ scope: { name: 'test' }, metrics: metricList }] }],
+});
+
+const logRecord = (overrides: Record<string, unknown> = {}) => ({
+  timeUnixNano: '1700000000000000000',
+  body: { stringValue: 'payment declined' },
+  ...overrides,
+});
+
+const logs = (records: any[], resource: any[] = [stringAttr('service.name', 'checkout')]) => ({
+  resourceLogs: [{ resource: { attributes: resource }, scopeLogs: [{ scope: { name: 'test' }, logRecords: records }] }],
+});
+
+describe('OtlpTransformer traces', () => {
+  it('flags spans with a 4-byte trace ID as malformed', () => {
+    const batch = transformer().transformTraces(traces([span({ traceId: '5b8efff7' }), span()]));
+    assert.deepEqual(batch.invalid, { malformed_trace_id: 1 });
+    assert.equal(batch.rows.length, 1);
+    assert.equal(batch.rows[0].TraceId, TRACE_ID);
+  });
+
+  it('skips and counts spans missing their IDs', () => {
+    const batch = transformer().transformTraces(traces([span({ traceId: '' }), span({ spanId: undefined }), span()]));
+    assert.deepEqual(batch.invalid, { missing_trace_id: 1, missing_span_id: 1 });
+    assert.equal(batch.rows.length, 1);
+  });
+});
+
+describe('OtlpTransformer metrics', () => {
+  it('skips and counts metrics without a name', () => {
+    const batch = transformer().transformMetrics(metrics([{ sum: { dataPoints: [point()] } }]));
+    assert.deepEqual(batch.invalid, { missing_name: 1 });
+    assert.equal(batch.rows.length, 0);
+  });
+});
+
+describe('OtlpTransformer timestamp precision', () => {
+  it('keeps nanoseconds all the way to the insert', async () => {
+    const inserted: any[] = [];
+    const client = {
+      query: async () => ({ json: async () => [] }),
+      insert: async ({ values }: { values: any[] }) => {
+        inserted.push(...values);
+        return {};
+      },
+    } as unknown as ClickHouseClient;
+    const service = new ClickHouseService(loadClickHouseConfig({}), client);
+
+    await service.insertTraces(transformer().transformTraces(traces([span()])).rows);
+    assert.equal(inserted[0].Timestamp, '2023-11-14 22:13:20.123456789');
+  });
+
+  it('truncates to the configured digits', () => {
+    const batch = transformer({ OTEL_TIMESTAMP_PRECISION: '3' }).transformTraces(traces([span()]));
+    assert.equal(batch.rows[0].Timestamp, '2023-11-14 22:13:20.123');
+    const seconds = transformer({ OTEL_TIMESTAMP_PRECISION: '0' }).transformTraces(traces([span()]));
+    assert.equal(seconds.rows[0].Timestamp, '2023-11-14 22:13:20');
+  });
+
+  it('falls back to nanoseconds for a non-numeric precision', () => {
+    const batch = transformer({ OTEL_TIMESTAMP_PRECISION: 'ms' }).transformTraces(traces([span()]));
+    assert.equal(batch.rows[0].Timestamp, '2023-11-14 22:13:20.123456789');
+  });
+});
+
+describe('OtlpTransformer sums', () => {
+  it('stores the real temporality and monotonicity', () => {
+    const batch = transformer().transformMetrics(
+      metrics([
+        {
+          name: 'http.server.active_requests',
+          sum: { aggregationTemporality: 'AGGREGATION_TEMPORALITY_DELTA', isMonotonic: false, dataPoints: [point()] },
+        },
+        { name: 'requests', sum: { aggregationTemporality: 2, isMonotonic: true, dataPoints: [point()] } },
+      ]),
+    );
+    assert.equal(batch.rows[0].AggTemp, 1);
+    assert.equal(batch.rows[0].IsMonotonic, false);
+    assert.equal(batch.rows[1].AggTemp, 2);
+    assert.equal(batch.rows[1].IsMonotonic, true);
+  });
+});
+
+describe('OtlpTransformer metric names', () => {
+  const counter = (name: string) => ({ name, sum: { dataPoints: [point()] } });
+
+  it('rewrites mapped names and normalizes the rest when enabled', () => {
+    const batch = transformer({
+      OTEL_METRIC_NAME_MAP: JSON.stringify({ 'http.server.duration': 'http_server_duration_ms' }),
+      OTEL_METRIC_NAME_NORMALIZE: 'true',
+    }).transformMetrics(metrics([counter('http.server.duration'), counter('process/cpu.time')]));
+
+    assert.deepEqual(
+      batch.rows.map((row) => row.MetricName),
+      ['http_server_duration_ms', 'process_cpu_time'],
+    );
+  });
+
+  it('leaves names alone by default', () => {
+    const batch = transformer().transformMetrics(metrics([counter('http.server.duration')]));
+    assert.equal(batch.rows[0].MetricName, 'http.server.duration');
+  });
+});
+
+describe('OtlpTransformer malformed attributes', () => {
+  it('skips attributes without a key or value instead of failing', () => {
+    const batch = transformer().transformTraces(
+      traces([
+        span({
+          attributes: [{ key: 'http.method', value: null }, { value: { stringValue: 'orphan' } }, null, stringAttr('http.route', '/')],
+        }),
+      ]),
+    );
+    assert.deepEqual(batch.rows[0].SpanAttributes, { 'http.route': '/' });
+    assert.deepEqual(batch.skippedAttributes, { missing_value: 1, missing_key: 2 });
+  });
+});
+
+describe('OtlpTransformer attribute cap', () => {
+  const attrs = ['e', 'c', 'a', 'd', 'b'].map((key) => stringAttr(key, key));
+
+  it('keeps the first keys in sorted order and counts the rest', () => {
+    const batch = transformer({ OTEL_MAX_ATTRIBUTES: '3', OTEL_RECORD_DROPPED_ATTRIBUTES: 'true' }).transformTraces(
+      traces([span({ attributes: attrs, droppedAttributesCount: 1 })]),
+    );
+    assert.deepEqual(batch.rows[0].SpanAttributes, { a: 'a', b: 'b', c: 'c', _dropped_attributes: '3' });
+    assert.deepEqual(batch.droppedAttributes, { limit: 2, upstream: 1 });
+  });
+
+  it('keeps everything when unset', () => {
+    const batch = transformer().transformTraces(traces([span({ attributes: attrs })]));
+    assert.equal(Object.keys(batch.rows[0].SpanAttributes).length, 5);
+    assert.deepEqual(batch.droppedAttributes, {});
+  });
+});
+
+describe('OtlpTransformer duplicate points', () => {
+  const resent = () =>
+    metrics([
+      { name: 'requests', sum: { dataPoints: [point({ asInt: '5' }), point({ asInt: '7' })] } },
+      { name: 'requests', sum: { dataPoints: [point({ asInt: '5', timeUnixNano: '1700000120000000000' })] } },
+    ]);
+
+  it('keeps the last point per series and timestamp when enabled', () => {
+    const batch = transformer({ OTEL_METRICS_DEDUPLICATE: 'true' }).transformMetrics(resent());
+    assert.deepEqual(
+      batch.rows.map((row) => row.Value),
+      [7, 5],
+    );
+  });
+
+  it('keeps every point by default', () => {
+    assert.equal(transformer().transformMetrics(resent()).rows.length, 3);
+  });
+});
+
+describe('OtlpTransformer attribute ordering', () => {
+  it('serializes the same attributes identically whatever order they arrive in', () => {
+    const attrs = [stringAttr('http.route', '/'), stringAttr('http.method', 'GET'), stringAttr('net.peer.name', 'db')];
+    const batch = transformer().transformTraces(
+      traces([span({ attributes: attrs }), span({ attributes: [...attrs].reverse() })]),
+    );
+    assert.equal(JSON.stringify(batch.rows[0].SpanAttributes), JSON.stringify(batch.rows[1].SpanAttributes));
+    assert.deepEqual(Object.keys(batch.rows[0].SpanAttributes), ['http.method', 'http.route', 'net.peer.name']);
+  });
+});
+
+describe('OtlpTransformer events routing', () => {
+  it('sends records carrying the events attribute to events and the rest to logs', () => {
+    // The cap keeps only "a", so routing must look at the uncapped attributes
+    const batch = transformer({ OTEL_EVENTS_ATTRIBUTE: 'event.domain', OTEL_MAX_ATTRIBUTES: '1' }).transformLogs(
+      logs([
+        logRecord({ attributes: [stringAttr('event.domain', 'orders'), stringAttr('a', 'first')] }),
+        logRecord({ attributes: [stringAttr('a', 'x')] }),
+      ]),
+    );
+    assert.equal(batch.events.length, 1);
+    assert.equal(batch.logs.length, 1);
+    assert.equal(batch.logs[0].LogAttributes.a, 'x');
+  });
+
+  it('keeps everything in logs when unset', () => {
+    const batch = transformer().transformLogs(logs([logRecord({ attributes: [stringAttr('event.domain', 'orders')] })]));
+    assert.equal(batch.events.length, 0);
+    assert.equal(batch.logs.length, 1);
+  });
+});
+
+describe('OtlpTransformer span kinds', () => {
+  it('stores kind names when enabled', () => {
+    const batch = transformer({ OTEL_SPAN_KIND_NAMES: 'true' }).transformTraces(
+      traces([span({ kind: 2 }), span({ kind: 'SPAN_KIND_CLIENT' })]),
+    );
+    assert.deepEqual(
+      batch.rows.map((row) => row.SpanKind),
+      ['SPAN_KIND_SERVER', 'SPAN_KIND_CLIENT'],
+    );
+  });
+
+  it('stores the numeric kind by default', () => {
+    const batch = transformer().transformTraces(traces([span({ kind: 'SPAN_KIND_SERVER' })]));
+    assert.equal(batch.rows[0].SpanKind, '2');
+  });
+});
+
+describe('OtlpTransformer resource flattening', () => {
+  const runtime = {
+    key: 'process.runtime',
+    value: { kvlistValue: { values: [stringAttr('name', 'nodejs'), stringAttr('version', '22.20.0')] } },
+  };
+
+  it('turns nested resource attributes into dotted keys when enabled', () => {
+    const batch = transformer({ OTEL_FLATTEN_RESOURCE_ATTRIBUTES: 'true' }).transformTraces(
+      traces([span()], [stringAttr('service.name', 'checkout'), runtime]),
+    );
+    assert.deepEqual(batch.rows[0].ResourceAttributes, {
+      'process.runtime.name': 'nodejs',
+      'process.runtime.version': '22.20.0',
+      'service.name': 'checkout',
+    });
+  });
+
+  it('keeps the nested attribute as one key by default', () => {
+    const batch = transformer().transformTraces(traces([span()], [stringAttr('service.name', 'checkout'), runtime]));
+    assert.deepEqual(Object.keys(batch.rows[0].ResourceAttributes), ['process.runtime', 'service.name']);
+  });
+});
+
+describe('OtlpTransformer point flags', () => {
+  it('skips points flagged NoRecordedValue and stores the flags of the rest', () => {
+    const batch = transformer().transformMetrics(
+      metrics([{ name: 'requests', sum: { dataPoints: [point({ flags: 1 }), point({ flags: 2, asInt: '3' })] } }]),
+    );
+    assert.equal(batch.rows.length, 1);
+    assert.equal(batch.rows[0].Value, 3);
+    assert.equal(batch.rows[0].Flags, 2);
+    assert.deepEqual(batch.droppedPoints, { no_recorded_value: 1 });
+  });
+});
+
+describe('OtlpTransformer log correlation', () => {
+  it('backfills a log service name from a trace seen earlier', () => {
+    const correlating = transformer({ OTEL_CORRELATE_LOGS: 'true' });
+    correlating.transformTraces(traces([span()]));
+
+    const batch = correlating.transformLogs(logs([logRecord({ traceId: TRACE_ID }), logRecord()], []));
+    assert.deepEqual(
+      batch.logs.map((row) => row.ServiceName),
+      ['checkout', 'unknown'],
+    );
+  });
+
+  it('leaves the service name alone when disabled', () => {
+    const plain = transformer();
+    plain.transformTraces(traces([span()]));
+    assert.equal(plain.transformLogs(logs([logRecord({ traceId: TRACE_ID })], [])).logs[0].ServiceName, 'unknown');
+  });
+});
+
+describe('OtlpTransformer instance tagging', () => {
+  it('adds the pod name to resource attributes when enabled', () => {
+    const batch = transformer({ OTEL_TAG_INSTANCE_ID: 'true', POD_NAME: 'ingest-7d9f' }).transformLogs(
+      logs([logRecord()]),
+    );
+    assert.deepEqual(batch.logs[0].ResourceAttributes, {
+      'appsentry.ingest.instance_id': 'ingest-7d9f',
+      'service.name': 'checkout',
+    });
+  });
+
+  it('leaves resource attributes untouched by default', () => {
+    const batch = transformer({ POD_NAME: 'ingest-7d9f' }).transformLogs(logs([logRecord()]));
+    assert.deepEqual(batch.logs[0].ResourceAttributes, { 'service.name': 'checkout' });
+  });
+});
+
+describe('OtlpTransformer per-metric point cap', () => {
+  it('truncates points beyond the cap and counts them per metric', () => {
+    const points = [1, 2, 3, 4].map((value) => point({ asInt: value.toString(), attributes: [stringAttr('le', `${value}`)] }));
+    const batch = transformer({ OTEL_MAX_POINTS_PER_METRIC: '2' }).transformMetrics(
+      metrics([{ name: 'requests', sum: { dataPoints: points } }, { name: 'errors', sum: { dataPoints: [point()] } }]),
+    );
+    assert.deepEqual(
+      batch.rows.map((row) => [row.MetricName, row.Value]),
+      [
+        ['requests', 1],
+        ['requests', 2],
+        ['errors', 5],
+      ],
+    );
+    assert.deepEqual(batch.droppedPoints, { point_cap: 2 });
+    assert.deepEqual(batch.truncated, { requests: 2 });
+  });
+});
+
+describe('OtlpTransformer metric drop rules', () => {
+  it('drops points matching a name pattern or carrying a label', () => {
+    const rules = [{ name: '^go_gc_' }, { name: '^http_', label: 'debug' }];
+    const batch = transformer({ OTEL_METRIC_DROP_RULES: JSON.stringify(rules) }).transformMetrics(
+      metrics([
+        { name: 'go_gc_duration_seconds', sum: { dataPoints: [point()] } },
+        {
+          name: 'http_requests_total',
+          sum: { dataPoints: [point({ attributes: [stringAttr('debug', 'true')] }), point()] },
+        },
+        { name: 'process_cpu_seconds', sum: { dataPoints: [point()] } },
+      ]),
+    );
+    assert.deepEqual(
+      batch.rows.map((row) => row.MetricName),
+      ['http_requests_total', 'process_cpu_seconds'],
+    );
+    assert.deepEqual(batch.droppedPoints, { drop_rule: 2 });
+  });
+
+  it('ignores rules with an invalid pattern', () => {
+    const batch = transformer({ OTEL_METRIC_DROP_RULES: JSON.stringify([{ name: '(' }]) }).transformMetrics(
+      metrics([{ name: 'requests', sum: { dataPoints: [point()] } }]),
+    );
+    assert.equal(batch.rows.length, 1);
+  });
+});
+
+describe('OtlpTransformer span events', () => {
+  const withEvents = () =>
+    traces([
+      span({
+        events: [{ timeUnixNano: '1700000000150000000', name: 'retry', attributes: [stringAttr('attempt', '2')] }],
+        links: [{ traceId: TRACE_ID, spanId: SPAN_ID, attributes: [] }],
+      }),
+    ]);
+
+  it('writes Nested columns by default', () => {
+    const row = transformer().transformTraces(withEvents()).rows[0];
+    assert.deepEqual(row['Events.Timestamp'], ['2023-11-14 22:13:20.150000000']);
+    assert.deepEqual(row['Events.Name'], ['retry']);
+    assert.deepEqual(row['Events.Attributes'], [{ attempt: '2' }]);
+    assert.deepEqual(row['Links.TraceId'], [TRACE_ID]);
+    assert.equal(row.Events, undefined);
+  });
+
+  it('writes JSON columns when configured', () => {
+    const row = transformer({ OTEL_SPAN_EVENTS_FORMAT: 'json' }).transformTraces(withEvents()).rows[0];
+    assert.deepEqual(JSON.parse(row.Events), [
+      { timestamp: '2023-11-14 22:13:20.150000000', name: 'retry', attributes: { attempt: '2' } },
+    ]);
+    assert.deepEqual(JSON.parse(row.Links), [{ traceId: TRACE_ID, spanId: SPAN_ID, traceState: '', attributes: {} }]);
+    assert.equal(row['Events.Name'], undefined);
+  });
+});
+
+describe('OtlpTransformer required resource attributes', () => {
+  const env = { OTEL_REQUIRED_RESOURCE_ATTRIBUTES: 'service.name, deployment.environment' };
+  const tagged = [stringAttr('service.name', 'checkout'), stringAttr('deployment.environment', 'production')];
+
+  it('rejects every record of a resource missing a required attribute', () => {
+    const batch = transformer(env).transformTraces(traces([span(), span()]));
+    assert.equal(batch.rows.length, 0);
+    assert.deepEqual(batch.invalid, { 'missing_resource_attribute:deployment.environment': 2 });
+  });
+
+  it('accepts resources carrying every required attribute', () => {
+    const batch = transformer(env).transformLogs(logs([logRecord()], tagged));
+    assert.equal(batch.logs.length, 1);
+    assert.deepEqual(batch.invalid, {});
+  });
+});
+
+describe('OtlpTransformer exemplars', () => {
+  it('converts filtered attributes to maps alongside the other exemplar columns', () => {
+    const exemplar = {
+      timeUnixNano: '1700000030000000000',
+      asDouble: 0.42,
+      traceId: TRACE_ID,
+      spanId: SPAN_ID,
+      filteredAttributes: [stringAttr('http.route', '/checkout'), { key: 'retry', value: { boolValue: true } }],
+    };
+    const row = transformer().transformMetrics(
+      metrics([{ name: 'latency', histogram: { dataPoints: [point({ sum: 1.5, exemplars: [exemplar] })] } }]),
+    ).rows[0];
+
+    assert.deepEqual(row['Exemplars.FilteredAttributes'], [{ 'http.route': '/checkout', retry: 'true' }]);
+    assert.deepEqual(row['Exemplars.TimeUnix'], ['2023-11-14 22:13:50.000000000']);
+    assert.deepEqual(row['Exemplars.Value'], [0.42]);
+    assert.deepEqual(row['Exemplars.TraceId'], [TRACE_ID]);
+    assert.deepEqual(row['Exemplars.SpanId'], [SPAN_ID]);
+  });
+});
+
+describe('OtlpTransformer status codes', () => {
+  const failed = () => traces([span({ status: { code: 2, message: 'timeout' } })]);
+
+  it('stores the number by default', () => {
+    const row = transformer().transformTraces(failed()).rows[0];
+    assert.equal(row.StatusCode, '2');
+    assert.equal(row.StatusMessage, 'timeout');
+  });
+
+  it('stores the name or both when configured', () => {
+    assert.equal(transformer({ OTEL_STATUS_CODE_FORMAT: 'name' }).transformTraces(failed()).rows[0].StatusCode, 'ERROR');
+    const both = transformer({ OTEL_STATUS_CODE_FORMAT: 'both' }).transformTraces(failed()).rows[0];
+    assert.equal(both.StatusCode, '2');
+    assert.equal(both.StatusCodeName, 'ERROR');
+  });
+});
+
+describe('OtlpTransformer duration unit', () => {
+  it('converts the 100ms span duration to the configured unit', () => {
+    const duration = (unit?: string) =>
+      transformer(unit ? { OTEL_DURATION_UNIT: unit } : {}).transformTraces(traces([span()])).rows[0].Duration;
+    assert.equal(duration(), 100000000);
+    assert.equal(duration('us'), 100000);
+    assert.equal(duration('ms'), 100);
+  });
+});
+
+describe('OtlpTransformer log timestamps', () => {
+  const observed = '1700000005000000000';
+
+  it('falls back to the observed time when the record time is zero', () => {
+    const batch = transformer({ OTEL_LOGS_OBSERVED_TIMESTAMP: 'true' }).transformLogs(
+      logs([logRecord({ timeUnixNano: '0', observedTimeUnixNano: observed })]),
+    );
+    assert.equal(batch.logs[0].Timestamp, '2023-11-14 22:13:25.000000000');
+    assert.equal(batch.logs[0].ObservedTimestamp, '2023-11-14 22:13:25.000000000');
+  });
+
+  it('uses the receive time when neither is set', () => {
+    const clock = mock.method(Date, 'now', () => 1700000010000);
+    try {
+      const batch = transformer().transformLogs(logs([logRecord({ timeUnixNano: undefined })]));
+      assert.equal(batch.logs[0].Timestamp, '2023-11-14 22:13:30.000000000');
+      assert.equal(batch.logs[0].ObservedTimestamp, undefined);
+    } finally {
+      clock.mock.restore();
+    }
+  });
+});
+
+describe('OtlpTransformer zero-time spans', () => {
+  const zeroStart = () => traces([span({ startTimeUnixNano: '0' }), span()]);
+
+  it('flags and stores them with a zero duration by default', () => {
+    const batch = transformer().transformTraces(zeroStart());
+    assert.equal(batch.rows.length, 2);
+    assert.equal(batch.rows[0].SpanAttributes['appsentry.zero_time'], 'zero_start_time');
+    assert.equal(batch.rows[0].Timestamp, '2023-11-14 22:13:20.223456789');
+    assert.equal(batch.rows[0].Duration, 0);
+    assert.deepEqual(batch.zeroTime, { zero_start_time: 1 });
+  });
+
+  it('skips and counts them when configured', () => {
+    const batch = transformer({ OTEL_ZERO_TIME_SPANS: 'skip' }).transformTraces(zeroStart());
+    assert.equal(batch.rows.length, 1);
+    assert.deepEqual(batch.invalid, { zero_start_time: 1 });
+  });
+});
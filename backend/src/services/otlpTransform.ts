@@ -0,0 +1,824 @@
+import os from 'os';
+import { logger } from '../utils/logger';
+import { GeoIpLookup } from './geoIp';
+import { DeltaToCumulative, SumPoint } from '../utils/deltaToCumulative';
+import {
+  encodeId,
+  isValidSpanId,
+  isValidTraceId,
+  normalizeSeverity,
+  SPAN_ID_PATTERN,
+  TRACE_ID_PATTERN,
+} from '../utils/otlpFormat';
+
+export type Signal = 'traces' | 'metrics' | 'logs';
+
+// Drop rules, e.g. [{"name":"^go_gc_"},{"label":"debug"}]. A rule matches when its name
+// pattern matches the stored metric name and its label key is present (when set).
+export interface MetricDropRule {
+  name?: RegExp;
+  label?: string;
+}
+
+export interface OtlpTransformConfig {
+  // Metric name rewrites, e.g. {"http.server.duration":"http_server_duration"}
+  metricNameMap: Record<string, string>;
+  normalizeMetricNames: boolean;
+  flattenResourceAttributes: boolean;
+  maxAttributes: number;
+  recordDroppedAttributes: boolean;
+  deduplicateMetricPoints: boolean;
+  // Logs carrying this attribute (e.g. event.domain) are written to the events table
+  eventsAttribute: string;
+  storeSpanKindNames: boolean;
+  // Unit of the stored span Duration; the trace queries assume the default of nanoseconds
+  durationUnit: string;
+  // number (default) stores 0/1/2, name stores UNSET/OK/ERROR, both adds a StatusCodeName column
+  statusCodeFormat: string;
+  // Fractional second digits kept in stored timestamps (9 = nanoseconds, 3 = milliseconds)
+  timestampPrecision: number;
+  // Storage queries assume cumulative sums, so delta sums can be accumulated per series.
+  // Running totals live in this process only: with more than one replica behind a load
+  // balancer each sees part of a series and stores its own partial total, so only enable
+  // this on a single replica or with the exporter pinned to one (e.g. sticky by service).
+  convertDeltaToCumulative: boolean;
+  deltaStateTtlMs: number;
+  metricDropRules: MetricDropRule[];
+  // Caps points stored per metric per request so one exploding metric can't flood a batch
+  maxPointsPerMetric: number;
+  correlateLogs: boolean;
+  correlationCacheSize: number;
+  // Stamped into every record's resource attributes when set
+  instanceId: string;
+  // Span events/links go into ClickHouse Nested columns, or as JSON strings in single Events/Links columns
+  spanEventsFormat: 'nested' | 'json';
+  storeObservedTimestamp: boolean;
+  // Encoding for stored IDs: hex (default, as sent by OTLP/JSON), URL-safe base64, or uuid.
+  // Span IDs are only 8 bytes, so uuid applies to trace IDs and span IDs stay hex.
+  idFormat: string;
+  // Spans with a zero start or end time are stored best-effort and flagged, or skipped
+  zeroTimeSpans: 'store' | 'skip';
+  // Resource attributes every record must carry, e.g. service.name and deployment.environment
+  requiredResourceAttributes: string[];
+}
+
+const parseJsonEnv = <T>(env: NodeJS.ProcessEnv, name: string, fallback: T): T => {
+  const raw = env[name];
+  if (!raw) return fallback;
+  try {
+    return JSON.parse(raw) as T;
+  } catch (error) {
+    logger.warn(`Ignoring invalid JSON in ${name}`, { error });
+    return fallback;
+  }
+};
+
+// Clamped to 0-9 digits; unparseable values fall back to nanoseconds
+const parseTimestampPrecision = (raw?: string): number => {
+  if (raw === undefined || raw === '') return 9;
+
+  const value = parseInt(raw);
+  if (Number.isNaN(value)) {
+    logger.warn(`Ignoring invalid OTEL_TIMESTAMP_PRECISION="${raw}", using 9`);
+    return 9;
+  }
+  return Math.min(9, Math.max(0, value));
+};
+
+const parseDropRules = (env: NodeJS.ProcessEnv): MetricDropRule[] =>
+  parseJsonEnv<Array<{ name?: string; label?: string }>>(env, 'OTEL_METRIC_DROP_RULES', []).flatMap((rule) => {
+    if (!rule.name && !rule.label) return [];
+    try {
+      return [{ name: rule.name ? new RegExp(rule.name) : undefined, label: rule.label }];
+    } catch (error) {
+      logger.warn('Ignoring metric drop rule with invalid pattern', { rule, error });
+      return [];
+    }
+  });
+
+export const loadOtlpTransformConfig = (env: NodeJS.ProcessEnv = process.env): OtlpTransformConfig => ({
+  metricNameMap: parseJsonEnv<Record<string, string>>(env, 'OTEL_METRIC_NAME_MAP', {}),
+  normalizeMetricNames: env.OTEL_METRIC_NAME_NORMALIZE === 'true',
+  flattenResourceAttributes: env.OTEL_FLATTEN_RESOURCE_ATTRIBUTES === 'true',
+  maxAttributes: parseInt(env.OTEL_MAX_ATTRIBUTES || '0'),
+  recordDroppedAttributes: env.OTEL_RECORD_DROPPED_ATTRIBUTES === 'true',
+  deduplicateMetricPoints: env.OTEL_METRICS_DEDUPLICATE === 'true',
+  eventsAttribute: env.OTEL_EVENTS_ATTRIBUTE || '',
+  storeSpanKindNames: env.OTEL_SPAN_KIND_NAMES === 'true',
+  durationUnit: env.OTEL_DURATION_UNIT || 'ns',
+  statusCodeFormat: env.OTEL_STATUS_CODE_FORMAT || 'number',
+  timestampPrecision: parseTimestampPrecision(env.OTEL_TIMESTAMP_PRECISION),
+  convertDeltaToCumulative: env.OTEL_DELTA_TO_CUMULATIVE === 'true',
+  deltaStateTtlMs: parseInt(env.OTEL_DELTA_STATE_TTL_MS || '3600000'),
+  metricDropRules: parseDropRules(env),
+  maxPointsPerMetric: parseInt(env.OTEL_MAX_POINTS_PER_METRIC || '0'),
+  correlateLogs: env.OTEL_CORRELATE_LOGS === 'true',
+  correlationCacheSize: parseInt(env.OTEL_CORRELATION_CACHE_SIZE || '10000'),
+  instanceId: env.OTEL_TAG_INSTANCE_ID === 'true' ? env.POD_NAME || env.HOSTNAME || os.hostname() : '',
+  spanEventsFormat: env.OTEL_SPAN_EVENTS_FORMAT === 'json' ? 'json' : 'nested',
+  storeObservedTimestamp: env.OTEL_LOGS_OBSERVED_TIMESTAMP === 'true',
+  idFormat: env.OTEL_ID_FORMAT || 'hex',
+  zeroTimeSpans: env.OTEL_ZERO_TIME_SPANS === 'skip' ? 'skip' : 'store',
+  requiredResourceAttributes: (env.OTEL_REQUIRED_RESOURCE_ATTRIBUTES || '')
+    .split(',')
+    .map((key) => key.trim())
+    .filter(Boolean),
+});
+
+// Counts gathered while transforming one request, reported by the caller
+export interface TransformStats {
+  // Services that sent records, after the unknown_service override
+  services: Set<string>;
+  // Records dropped as invalid, by reason
+  invalid: Record<string, number>;
+  // Attributes skipped for a missing key or value
+  skippedAttributes: Record<string, number>;
+  // Span and log attributes dropped by the attribute cap or upstream
+  droppedAttributes: Record<string, number>;
+}
+
+export interface TraceBatch extends TransformStats {
+  rows: any[];
+  // Spans stored with a zero start or end time, by reason
+  zeroTime: Record<string, number>;
+}
+
+export interface MetricBatch extends TransformStats {
+  rows: any[];
+  // Valid points not stored: drop_rule, no_recorded_value, point_cap, out_of_order
+  droppedPoints: Record<string, number>;
+  // Points over the per-metric cap, by metric name
+  truncated: Record<string, number>;
+}
+
+export interface LogBatch extends TransformStats {
+  logs: any[];
+  events: any[];
+}
+
+const newStats = (): TransformStats => ({
+  services: new Set<string>(),
+  invalid: {},
+  skippedAttributes: {},
+  droppedAttributes: {},
+});
+
+const countInvalid = (counts: Record<string, number>, reason: string, amount = 1): void => {
+  counts[reason] = (counts[reason] || 0) + amount;
+};
+
+const countRecords = (scopes: any[] | undefined, field: string): number =>
+  (scopes || []).reduce((total, scope) => total + (scope[field]?.length || 0), 0);
+
+const attributeValueToString = (value: any): string | undefined => {
+  if (!value) return undefined;
+  if (value.stringValue !== undefined) return value.stringValue;
+  if (value.intValue !== undefined) return value.intValue.toString();
+  if (value.boolValue !== undefined) return value.boolValue.toString();
+  if (value.doubleValue !== undefined) return value.doubleValue.toString();
+  return '';
+};
+
+// Some SDKs send entries without a key or value; skip them instead of failing the request.
+// With flatten set, nested kvlists become dotted keys (process.runtime.name).
+const collectAttributes = (
+  attributes: any,
+  prefix: string,
+  flatten: boolean,
+  into: Record<string, string>,
+  skipped: Record<string, number>,
+): void => {
+  if (!Array.isArray(attributes)) return;
+
+  attributes.forEach((attr: any) => {
+    if (!attr?.key) {
+      countInvalid(skipped, 'missing_key');
+      return;
+    }
+    const key = prefix + attr.key;
+
+    if (flatten && attr.value?.kvlistValue) {
+      collectAttributes(attr.value.kvlistValue.values, `${key}.`, flatten, into, skipped);
+      return;
+    }
+
+    const value = attributeValueToString(attr.value);
+    if (value !== undefined) {
+      into[key] = value;
+    } else {
+      countInvalid(skipped, 'missing_value');
+    }
+  });
+};
+
+// Keys are emitted in sorted order so identical attribute sets serialize identically
+const attributesToMap = (attributes: any, stats: TransformStats, flatten = false): Record<string, string> => {
+  const values: Record<string, string> = {};
+  collectAttributes(attributes, '', flatten, values, stats.skippedAttributes);
+
+  const result: Record<string, string> = {};
+  Object.keys(values)
+    .sort()
+    .forEach((key) => {
+      result[key] = values[key];
+    });
+  return result;
+};
+
+const sortedEntriesKey = (attributes: Record<string, string>): string =>
+  JSON.stringify(Object.keys(attributes).sort().map((key) => [key, attributes[key]]));
+
+// Collector retries can resend identical points; keep the last one per series and timestamp
+const deduplicateMetricPoints = (records: any[]): any[] => {
+  const byIdentity = new Map<string, any>();
+  records.forEach((record) => {
+    const key = [
+      record.MetricName,
+      sortedEntriesKey(record.ResourceAttributes),
+      sortedEntriesKey(record.Attributes),
+      record.TimeUnix,
+    ].join('|');
+    byIdentity.set(key, record);
+  });
+  return Array.from(byIdentity.values());
+};
+
+const SPAN_KIND_NAMES = [
+  'SPAN_KIND_UNSPECIFIED',
+  'SPAN_KIND_INTERNAL',
+  'SPAN_KIND_SERVER',
+  'SPAN_KIND_CLIENT',
+  'SPAN_KIND_PRODUCER',
+  'SPAN_KIND_CONSUMER',
+];
+
+const DURATION_DIVISORS: Record<string, bigint> = { ns: BigInt(1), us: BigInt(1000), ms: BigInt(1000000) };
+
+const STATUS_CODE_NAMES = ['UNSET', 'OK', 'ERROR'];
+
+// Accepts the numeric code or its proto name (STATUS_CODE_ERROR) as sent by OTLP/JSON
+const parseStatusCode = (code: unknown): number => {
+  if (typeof code === 'number') return code;
+  if (typeof code === 'string') {
+    const index = STATUS_CODE_NAMES.indexOf(code.replace(/^STATUS_CODE_/, ''));
+    return index >= 0 ? index : parseInt(code) || 0;
+  }
+  return 0;
+};
+
+// Nanosecond timestamps exceed Number precision, so parse them as BigInt
+const parseUnixNano = (value: unknown): bigint => {
+  if (typeof value === 'bigint') return value;
+  try {
+    return BigInt(typeof value === 'string' || typeof value === 'number' ? value : 0);
+  } catch {
+    return BigInt(0);
+  }
+};
+
+const AGGREGATION_TEMPORALITY_DELTA = 1;
+const AGGREGATION_TEMPORALITY_CUMULATIVE = 2;
+
+// OTLP/JSON may send enums either as numbers or as their proto names
+const AGGREGATION_TEMPORALITY: Record<string, number> = {
+  AGGREGATION_TEMPORALITY_UNSPECIFIED: 0,
+  AGGREGATION_TEMPORALITY_DELTA: 1,
+  AGGREGATION_TEMPORALITY_CUMULATIVE: 2,
+};
+
+const parseAggregationTemporality = (value: unknown): number => {
+  if (typeof value === 'number') return value;
+  if (typeof value === 'string') return AGGREGATION_TEMPORALITY[value] ?? (parseInt(value) || 0);
+  return 0;
+};
+
+// DataPointFlags bit marking a gap rather than a real value
+const FLAG_NO_RECORDED_VALUE = 1;
+
+const hasNoRecordedValue = (dataPoint: any): boolean =>
+  ((Number(dataPoint.flags) || 0) & FLAG_NO_RECORDED_VALUE) !== 0;
+
+const ZERO_TIME_ATTRIBUTE = 'appsentry.zero_time';
+
+const zeroTimeReason = (start: bigint, end: bigint): string | undefined => {
+  const zero = BigInt(0);
+  if (start === zero && end === zero) return 'zero_start_and_end_time';
+  if (start === zero) return 'zero_start_time';
+  if (end === zero) return 'zero_end_time';
+  return undefined;
+};
+
+// Returns why a span can't be stored, or undefined when its identifiers are usable
+const invalidSpanReason = (span: any): string | undefined => {
+  if (!span.traceId) return 'missing_trace_id';
+  if (!span.spanId) return 'missing_span_id';
+  if (!isValidTraceId(span.traceId)) return 'malformed_trace_id';
+  if (!isValidSpanId(span.spanId)) return 'malformed_span_id';
+  return undefined;
+};
+
+// Services without service.name set show up as unknown_service:node; that's this backend
+const overrideServiceName = (serviceName: string): string =>
+  serviceName.includes('unknown_service:') || serviceName.includes('/node') ? 'AppSentry Backend' : serviceName;
+
+interface PendingDelta {
+  seriesKey: string;
+  point: SumPoint;
+  monotonic: boolean;
+}
+
+// Turns OTLP/JSON requests into rows for the telemetry sink. Delta-to-cumulative totals and
+// the trace-to-service cache used to backfill log service names live here between requests.
+export class OtlpTransformer {
+  private config: OtlpTransformConfig;
+  private deltaToCumulative: DeltaToCumulative;
+  private traceServices = new Map<string, string>();
+  private geoIp?: GeoIpLookup;
+
+  constructor(config: OtlpTransformConfig, geoIp?: GeoIpLookup) {
+    this.config = config;
+    this.geoIp = geoIp;
+    this.deltaToCumulative = new DeltaToCumulative(config.deltaStateTtlMs);
+    if (config.convertDeltaToCumulative) {
+      logger.warn('Delta-to-cumulative state is per process; run a single ingestion replica or pin series to one');
+    }
+  }
+
+  getConfig(): OtlpTransformConfig {
+    return this.config;
+  }
+
+  transformTraces(traces: any): TraceBatch {
+    const batch: TraceBatch = { ...newStats(), rows: [], zeroTime: {} };
+    const durationDivisor = DURATION_DIVISORS[this.config.durationUnit] ?? DURATION_DIVISORS.ns;
+
+    traces?.resourceSpans?.forEach((resourceSpan: any) => {
+      const resourceAttributes = this.resourceAttributes(resourceSpan.resource, batch);
+      const missingAttribute = this.missingResourceAttribute(resourceAttributes);
+      if (missingAttribute) {
+        countInvalid(batch.invalid, `missing_resource_attribute:${missingAttribute}`, countRecords(resourceSpan.scopeSpans, 'spans'));
+        return;
+      }
+      const serviceName = resourceAttributes['service.name'] || 'unknown';
+      const cleanServiceName = overrideServiceName(serviceName);
+      batch.services.add(cleanServiceName);
+
+      resourceSpan.scopeSpans?.forEach((scopeSpan: any) => {
+        scopeSpan.spans?.forEach((span: any) => {
+          // Skip spans with truncated or missing IDs, they corrupt trace joins
+          const invalidReason = invalidSpanReason(span);
+          if (invalidReason) {
+            countInvalid(batch.invalid, invalidReason);
+            logger.debug('Dropping span with invalid IDs', {
+              reason: invalidReason,
+              traceId: span.traceId,
+              spanId: span.spanId,
+              serviceName,
+            });
+            return;
+          }
+
+          let startTime = parseUnixNano(span.startTimeUnixNano);
+          let endTime = parseUnixNano(span.endTimeUnixNano);
+          const zeroTime = zeroTimeReason(startTime, endTime);
+          if (zeroTime) {
+            if (this.config.zeroTimeSpans === 'skip') {
+              countInvalid(batch.invalid, zeroTime);
+              return;
+            }
+            // Best effort: fall back to whichever time is set and record a zero duration
+            countInvalid(batch.zeroTime, zeroTime);
+            startTime = startTime || endTime;
+            endTime = startTime;
+          }
+
+          // Subtract as BigInt so nanosecond durations stay exact
+          const duration = Number((endTime - startTime) / durationDivisor);
+
+          const spanAttributes = this.withGeo(
+            this.capAttributes(attributesToMap(span.attributes, batch), batch, span.droppedAttributesCount || 0),
+          );
+          if (zeroTime) {
+            spanAttributes[ZERO_TIME_ATTRIBUTE] = zeroTime;
+          }
+          const resourceAttrs: Record<string, string> = { ...resourceAttributes };
+
+          // Override service name if it's the auto-detected one
+          if (cleanServiceName !== serviceName) {
+            resourceAttrs['service.name'] = cleanServiceName;
+          }
+
+          // Create minimal trace record with only required fields
+          const traceRecord: any = {
+            Timestamp: this.formatUnixNano(startTime),
+            TraceId: this.formatTraceId(span.traceId),
+            SpanId: this.formatSpanId(span.spanId),
+            ParentSpanId: this.formatSpanId(span.parentSpanId),
+            TraceState: '',
+            SpanName: span.name || '',
+            SpanKind: this.formatSpanKind(span.kind),
+            ServiceName: cleanServiceName || 'unknown',
+            ResourceAttributes: resourceAttrs,
+            ScopeName: scopeSpan.scope?.name || '',
+            ScopeVersion: scopeSpan.scope?.version || '',
+            SpanAttributes: spanAttributes,
+            Duration: duration,
+            ...this.statusColumns(span.status),
+            StatusMessage: span.status?.message || '',
+          };
+
+          Object.assign(traceRecord, this.spanEventColumns(span, batch));
+
+          this.rememberTraceService(traceRecord.TraceId, traceRecord.ServiceName);
+          batch.rows.push(traceRecord);
+        });
+      });
+    });
+
+    return batch;
+  }
+
+  transformMetrics(metrics: any): MetricBatch {
+    const batch: MetricBatch = { ...newStats(), rows: [], droppedPoints: {}, truncated: {} };
+    const metricData: any[] = [];
+    const pendingDeltas = new Map<any, PendingDelta>();
+
+    metrics?.resourceMetrics?.forEach((resourceMetric: any) => {
+      const resourceAttrs = this.resourceAttributes(resourceMetric.resource, batch);
+      const missingAttribute = this.missingResourceAttribute(resourceAttrs);
+      if (missingAttribute) {
+        countInvalid(batch.invalid, `missing_resource_attribute:${missingAttribute}`, countRecords(resourceMetric.scopeMetrics, 'metrics'));
+        return;
+      }
+
+      // Override service name if needed for metrics
+      const serviceName = resourceAttrs['service.name'] || 'unknown';
+      const cleanServiceName = overrideServiceName(serviceName);
+      if (cleanServiceName !== serviceName) {
+        resourceAttrs['service.name'] = cleanServiceName;
+      }
+      batch.services.add(cleanServiceName);
+
+      resourceMetric.scopeMetrics?.forEach((scopeMetric: any) => {
+        scopeMetric.metrics?.forEach((metric: any) => {
+          if (!metric.name) {
+            countInvalid(batch.invalid, 'missing_name');
+            return;
+          }
+
+          const metricName = this.rewriteMetricName(metric.name);
+          let acceptedPoints = 0;
+          // Applies the no-recorded-value flag, drop rules and the per-metric cap in that order,
+          // returning the point's attributes when it is kept
+          const acceptPoint = (dataPoint: any): Record<string, string> | undefined => {
+            if (hasNoRecordedValue(dataPoint)) {
+              countInvalid(batch.droppedPoints, 'no_recorded_value');
+              return undefined;
+            }
+            const metricAttrs = attributesToMap(dataPoint.attributes, batch);
+            if (this.matchesDropRule(metricName, metricAttrs)) {
+              countInvalid(batch.droppedPoints, 'drop_rule');
+              return undefined;
+            }
+            if (this.config.maxPointsPerMetric > 0 && acceptedPoints >= this.config.maxPointsPerMetric) {
+              countInvalid(batch.droppedPoints, 'point_cap');
+              countInvalid(batch.truncated, metricName);
+              return undefined;
+            }
+            acceptedPoints++;
+            return metricAttrs;
+          };
+
+          const baseRecord = (dataPoint: any, metricAttrs: Record<string, string>) => ({
+            ResourceAttributes: resourceAttrs,
+            ResourceSchemaUrl: '',
+            ScopeName: scopeMetric.scope?.name || '',
+            ScopeVersion: scopeMetric.scope?.version || '',
+            ScopeAttributes: {},
+            ScopeDroppedAttrCount: 0,
+            ScopeSchemaUrl: '',
+            MetricName: metricName,
+            MetricDescription: metric.description || '',
+            MetricUnit: metric.unit || '',
+            Attributes: metricAttrs,
+            StartTimeUnix: this.formatUnixNano(dataPoint.startTimeUnixNano),
+            TimeUnix: this.formatUnixNano(dataPoint.timeUnixNano),
+          });
+
+          // Process sum metrics (counters)
+          metric.sum?.dataPoints?.forEach((dataPoint: any) => {
+            const metricAttrs = acceptPoint(dataPoint);
+            if (!metricAttrs) return;
+
+            const isMonotonic = metric.sum.isMonotonic === true;
+            const aggTemp = parseAggregationTemporality(metric.sum.aggregationTemporality);
+            const point = {
+              value: Number(dataPoint.asDouble ?? dataPoint.asInt ?? 0),
+              startTimeUnixNano: parseUnixNano(dataPoint.startTimeUnixNano),
+              timeUnixNano: parseUnixNano(dataPoint.timeUnixNano),
+            };
+
+            const metricRecord = {
+              ...baseRecord(dataPoint, metricAttrs),
+              Value: point.value,
+              Flags: Number(dataPoint.flags) || 0,
+              ...this.exemplarColumns(dataPoint, batch),
+              AggTemp: aggTemp,
+              IsMonotonic: isMonotonic,
+            };
+
+            metricData.push(metricRecord);
+            if (this.config.convertDeltaToCumulative && aggTemp === AGGREGATION_TEMPORALITY_DELTA) {
+              const seriesKey = [metricName, sortedEntriesKey(resourceAttrs), sortedEntriesKey(metricAttrs)].join('|');
+              pendingDeltas.set(metricRecord, { seriesKey, point, monotonic: isMonotonic });
+            }
+          });
+
+          // Process histogram metrics
+          metric.histogram?.dataPoints?.forEach((dataPoint: any) => {
+            const metricAttrs = acceptPoint(dataPoint);
+            if (!metricAttrs) return;
+
+            // For histograms, store the sum as a sum metric
+            metricData.push({
+              ...baseRecord(dataPoint, metricAttrs),
+              Value: dataPoint.sum || 0,
+              Flags: Number(dataPoint.flags) || 0,
+              ...this.exemplarColumns(dataPoint, batch),
+              AggTemp: parseAggregationTemporality(metric.histogram.aggregationTemporality),
+              IsMonotonic: false,
+            });
+          });
+        });
+      });
+    });
+
+    const uniqueRows = this.config.deduplicateMetricPoints ? deduplicateMetricPoints(metricData) : metricData;
+    const { rows, outOfOrder } = this.convertDeltaRows(uniqueRows, pendingDeltas);
+    if (outOfOrder > 0) {
+      countInvalid(batch.droppedPoints, 'out_of_order', outOfOrder);
+    }
+    batch.rows = rows;
+    return batch;
+  }
+
+  transformLogs(logs: any): LogBatch {
+    const batch: LogBatch = { ...newStats(), logs: [], events: [] };
+
+    logs?.resourceLogs?.forEach((resourceLog: any) => {
+      const resourceAttrs = this.resourceAttributes(resourceLog.resource, batch);
+      const missingAttribute = this.missingResourceAttribute(resourceAttrs);
+      if (missingAttribute) {
+        countInvalid(batch.invalid, `missing_resource_attribute:${missingAttribute}`, countRecords(resourceLog.scopeLogs, 'logRecords'));
+        return;
+      }
+      const serviceName = resourceAttrs['service.name'] || 'unknown';
+
+      // Override service name if needed
+      const cleanServiceName = overrideServiceName(serviceName);
+      if (cleanServiceName !== serviceName) {
+        resourceAttrs['service.name'] = cleanServiceName;
+      }
+      batch.services.add(cleanServiceName);
+
+      resourceLog.scopeLogs?.forEach((scopeLog: any) => {
+        scopeLog.logRecords?.forEach((logRecord: any) => {
+          const allLogAttrs = attributesToMap(logRecord.attributes, batch);
+          const logAttrs = this.withGeo(
+            this.capAttributes(allLogAttrs, batch, logRecord.droppedAttributesCount || 0),
+          );
+          const severity = normalizeSeverity(logRecord);
+
+          const logRow = {
+            ...this.logTimestampColumns(logRecord),
+            TraceId: this.formatTraceId(logRecord.traceId),
+            SpanId: this.formatSpanId(logRecord.spanId),
+            TraceFlags: 0,
+            SeverityText: severity.text,
+            SeverityNumber: severity.number,
+            ServiceName:
+              resourceAttrs['service.name'] ||
+              (this.config.correlateLogs && this.traceServices.get(this.formatTraceId(logRecord.traceId))) ||
+              serviceName,
+            Body: logRecord.body?.stringValue || '',
+            ResourceSchemaUrl: '',
+            ResourceAttributes: resourceAttrs,
+            ScopeSchemaUrl: '',
+            ScopeName: scopeLog.scope?.name || '',
+            ScopeVersion: scopeLog.scope?.version || '',
+            ScopeAttributes: {},
+            LogAttributes: logAttrs,
+          };
+
+          // Route on the uncapped attributes so the attribute cap can't hide the routing key
+          if (this.config.eventsAttribute && allLogAttrs[this.config.eventsAttribute] !== undefined) {
+            batch.events.push(logRow);
+          } else {
+            batch.logs.push(logRow);
+          }
+        });
+      });
+    });
+
+    return batch;
+  }
+
+  // Formats a Unix nanosecond timestamp as a ClickHouse DateTime64 string
+  formatUnixNano(value: unknown): string {
+    const nanos = parseUnixNano(value);
+    const nanosPerSecond = BigInt(1000000000);
+    const seconds = new Date(Number(nanos / nanosPerSecond) * 1000).toISOString().slice(0, 19).replace('T', ' ');
+    if (this.config.timestampPrecision === 0) return seconds;
+
+    const fraction = (nanos % nanosPerSecond).toString().padStart(9, '0');
+    return `${seconds}.${fraction.slice(0, this.config.timestampPrecision)}`;
+  }
+
+  private formatTraceId(traceId: unknown): string {
+    return encodeId(traceId, TRACE_ID_PATTERN, true, this.config.idFormat);
+  }
+
+  private formatSpanId(spanId: unknown): string {
+    return encodeId(spanId, SPAN_ID_PATTERN, false, this.config.idFormat);
+  }
+
+  private resourceAttributes(resource: any, stats: TransformStats): Record<string, string> {
+    const attributes = this.withGeo(
+      attributesToMap(resource?.attributes, stats, this.config.flattenResourceAttributes),
+    );
+    if (!this.config.instanceId) return attributes;
+
+    // Optionally stamp every record with the pod/instance that ingested it
+    const tagged: Record<string, string> = { ...attributes, 'appsentry.ingest.instance_id': this.config.instanceId };
+    return Object.fromEntries(Object.keys(tagged).sort().map((key) => [key, tagged[key]]));
+  }
+
+  // Adds country/ASN attributes for client.address. Applied after the attribute cap so
+  // enrichment never pushes the sender's own attributes out.
+  private withGeo(attributes: Record<string, string>): Record<string, string> {
+    const address = attributes['client.address'];
+    const geo = address && this.geoIp?.lookup(address);
+    return geo ? { ...attributes, ...geo } : attributes;
+  }
+
+  private missingResourceAttribute(attrs: Record<string, string>): string | undefined {
+    return this.config.requiredResourceAttributes.find((key) => !attrs[key]);
+  }
+
+  // Keep the first maxAttributes keys in sorted order so truncation is deterministic
+  private capAttributes(
+    attributes: Record<string, string>,
+    stats: TransformStats,
+    upstreamDropped = 0,
+  ): Record<string, string> {
+    const keys = Object.keys(attributes).sort();
+    const max = this.config.maxAttributes;
+    const dropped = max > 0 ? Math.max(0, keys.length - max) : 0;
+    if (dropped === 0 && upstreamDropped === 0) return attributes;
+
+    if (dropped > 0) {
+      countInvalid(stats.droppedAttributes, 'limit', dropped);
+    }
+    if (upstreamDropped > 0) {
+      countInvalid(stats.droppedAttributes, 'upstream', upstreamDropped);
+    }
+
+    const result: Record<string, string> = {};
+    keys.slice(0, keys.length - dropped).forEach((key) => {
+      result[key] = attributes[key];
+    });
+
+    if (this.config.recordDroppedAttributes) {
+      result['_dropped_attributes'] = (dropped + upstreamDropped).toString();
+    }
+    return result;
+  }
+
+  private rewriteMetricName(name: string): string {
+    if (this.config.metricNameMap[name]) return this.config.metricNameMap[name];
+    return this.config.normalizeMetricNames ? name.replace(/[^a-zA-Z0-9_:]/g, '_') : name;
+  }
+
+  private matchesDropRule(metricName: string, attributes: Record<string, string>): boolean {
+    return this.config.metricDropRules.some(
+      (rule) =>
+        (!rule.name || rule.name.test(metricName)) && (!rule.label || attributes[rule.label] !== undefined),
+    );
+  }
+
+  // Stores the numeric kind by default, or its OTLP name when storeSpanKindNames is set
+  private formatSpanKind(kind: unknown): string {
+    let value = 1;
+    if (typeof kind === 'number') {
+      value = kind;
+    } else if (typeof kind === 'string') {
+      value = SPAN_KIND_NAMES.includes(kind) ? SPAN_KIND_NAMES.indexOf(kind) : parseInt(kind) || 0;
+    }
+    return this.config.storeSpanKindNames ? SPAN_KIND_NAMES[value] || value.toString() : value.toString();
+  }
+
+  private statusColumns(status: any): Record<string, string> {
+    const code = parseStatusCode(status?.code);
+    const name = STATUS_CODE_NAMES[code] || code.toString();
+    if (this.config.statusCodeFormat === 'name') return { StatusCode: name };
+    if (this.config.statusCodeFormat === 'both') return { StatusCode: code.toString(), StatusCodeName: name };
+    return { StatusCode: code.toString() };
+  }
+
+  private spanEventColumns(span: any, stats: TransformStats): Record<string, unknown> {
+    const events = (Array.isArray(span.events) ? span.events : []).map((event: any) => ({
+      timestamp: this.formatUnixNano(event.timeUnixNano),
+      name: event.name || '',
+      attributes: attributesToMap(event.attributes, stats),
+    }));
+    const links = (Array.isArray(span.links) ? span.links : []).map((link: any) => ({
+      traceId: this.formatTraceId(link.traceId),
+      spanId: this.formatSpanId(link.spanId),
+      traceState: link.traceState || '',
+      attributes: attributesToMap(link.attributes, stats),
+    }));
+
+    if (this.config.spanEventsFormat === 'json') {
+      return { Events: JSON.stringify(events), Links: JSON.stringify(links) };
+    }
+
+    return {
+      'Events.Timestamp': events.map((event: any) => event.timestamp),
+      'Events.Name': events.map((event: any) => event.name),
+      'Events.Attributes': events.map((event: any) => event.attributes),
+      'Links.TraceId': links.map((link: any) => link.traceId),
+      'Links.SpanId': links.map((link: any) => link.spanId),
+      'Links.TraceState': links.map((link: any) => link.traceState),
+      'Links.Attributes': links.map((link: any) => link.attributes),
+    };
+  }
+
+  // Exemplar filtered attributes arrive as KeyValue lists but the column stores maps
+  private exemplarColumns(dataPoint: any, stats: TransformStats): Record<string, unknown[]> {
+    const exemplars: any[] = Array.isArray(dataPoint.exemplars) ? dataPoint.exemplars : [];
+    return {
+      'Exemplars.FilteredAttributes': exemplars.map((exemplar) => attributesToMap(exemplar.filteredAttributes, stats)),
+      'Exemplars.TimeUnix': exemplars.map((exemplar) => this.formatUnixNano(exemplar.timeUnixNano)),
+      'Exemplars.Value': exemplars.map((exemplar) => Number(exemplar.asDouble ?? exemplar.asInt ?? 0)),
+      'Exemplars.SpanId': exemplars.map((exemplar) => this.formatSpanId(exemplar.spanId)),
+      'Exemplars.TraceId': exemplars.map((exemplar) => this.formatTraceId(exemplar.traceId)),
+    };
+  }
+
+  // Collector-side log processing often sets only the observed time. Records with neither
+  // set are treated as observed on receipt rather than stored at the epoch.
+  private logTimestampColumns(logRecord: any): Record<string, string> {
+    const sentObserved = parseUnixNano(logRecord.observedTimeUnixNano);
+    const observed = sentObserved === BigInt(0) ? BigInt(Date.now()) * BigInt(1000000) : sentObserved;
+    const time = parseUnixNano(logRecord.timeUnixNano);
+    const columns: Record<string, string> = {
+      Timestamp: this.formatUnixNano(time === BigInt(0) ? observed : time),
+    };
+    if (this.config.storeObservedTimestamp) {
+      columns.ObservedTimestamp = this.formatUnixNano(observed);
+    }
+    return columns;
+  }
+
+  // Recently seen trace IDs and their service, used to backfill logs that lack a service name
+  private rememberTraceService(traceId: string, serviceName: string): void {
+    if (!this.config.correlateLogs || !traceId || serviceName === 'unknown') return;
+
+    this.traceServices.delete(traceId);
+    this.traceServices.set(traceId, serviceName);
+    if (this.traceServices.size > this.config.correlationCacheSize) {
+      const oldest = this.traceServices.keys().next().value;
+      if (oldest !== undefined) this.traceServices.delete(oldest);
+    }
+  }
+
+  // Runs after deduplication so a resent point isn't added twice, oldest first so points
+  // within one request don't look out of order. Rows the converter rejects are dropped.
+  private convertDeltaRows(rows: any[], pending: Map<any, PendingDelta>): { rows: any[]; outOfOrder: number } {
+    const dropped = new Set<any>();
+    rows
+      .filter((row) => pending.has(row))
+      .sort((a, b) => {
+        const left = pending.get(a)!.point.timeUnixNano;
+        const right = pending.get(b)!.point.timeUnixNano;
+        return left < right ? -1 : left > right ? 1 : 0;
+      })
+      .forEach((row) => {
+        const { seriesKey, point, monotonic } = pending.get(row)!;
+        const cumulative = this.deltaToCumulative.convert(seriesKey, point, monotonic);
+        if (!cumulative) {
+          dropped.add(row);
+          return;
+        }
+        row.StartTimeUnix = this.formatUnixNano(cumulative.startTimeUnixNano);
+        row.TimeUnix = this.formatUnixNano(cumulative.timeUnixNano);
+        row.Value = cumulative.value;
+        row.AggTemp = AGGREGATION_TEMPORALITY_CUMULATIVE;
+      });
+
+    return {
+      rows: dropped.size > 0 ? rows.filter((row) => !dropped.has(row)) : rows,
+      outOfOrder: dropped.size,
+    };
+  }
+}
@@ -0,0 +1,44 @@
+import { describe, it } from 'node:test';
+import assert from 'node:assert/strict';
+import { SignalToggles, ToggleStore } from './signalToggles';
+
+const memoryStore = (): ToggleStore => {
+  const values = new Map<string, string>();
+  return {
+    get: async <T>(key: string) => (values.has(key) ? (JSON.parse(values.get(key)!) as T) : null),
+    set: async (key: string, value: unknown) => {
+      values.set(key, JSON.stringify(value));
+    },
+  };
+};
+
+describe('SignalToggles', () => {
+  it('shares toggles between replicas through the store', async () => {
+    const store = memoryStore();
+    const first = new SignalToggles(store, 0);
+    const second = new SignalToggles(store, 0);
+
+    await first.update({ logs: false });
+    assert.equal(second.isEnabled('logs'), true);
+
+    await second.refresh();
+    assert.equal(second.isEnabled('logs'), false);
+    assert.equal(second.isEnabled('traces'), true);
+    assert.equal(second.scope, 'shared');
+  });
+
+  it('keeps another replica\'s change when updating a different signal', async () => {
+    const store = memoryStore();
+    const first = new SignalToggles(store, 0);
+    const second = new SignalToggles(store, 0);
+
+    await first.update({ logs: false });
+    assert.deepEqual(await second.update({ metrics: false }), { traces: true, metrics: false, logs: false });
+  });
+
+  it('applies to this instance only without a store and ignores non-boolean values', async () => {
+    const toggles = new SignalToggles();
+    assert.deepEqual(await toggles.update({ traces: false, logs: 'no' }), { traces: false, metrics: true, logs: true });
+    assert.equal(toggles.scope, 'instance');
+  });
+});
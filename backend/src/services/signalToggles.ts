@@ -0,0 +1,66 @@
+import { logThrottledError } from '../utils/throttledLogger';
+import { Signal } from './otlpTransform';
+
+// Shared storage for the toggles; RedisClient satisfies this
+export interface ToggleStore {
+  get<T>(key: string): Promise<T | null>;
+  set(key: string, value: unknown): Promise<void>;
+}
+
+const STORE_KEY = 'appsentry:otel:signal-writes';
+
+// Runtime write toggles for incident mitigation. With a store every replica follows the
+// same toggles, re-read every refreshMs; without one they only apply to this instance.
+export class SignalToggles {
+  private writes: Record<Signal, boolean> = { traces: true, metrics: true, logs: true };
+  private store?: ToggleStore;
+
+  constructor(store?: ToggleStore, refreshMs = 5000) {
+    this.store = store;
+    if (store && refreshMs > 0) {
+      setInterval(() => void this.refresh(), refreshMs).unref();
+    }
+  }
+
+  get scope(): 'shared' | 'instance' {
+    return this.store ? 'shared' : 'instance';
+  }
+
+  isEnabled(signal: Signal): boolean {
+    return this.writes[signal];
+  }
+
+  current(): Record<Signal, boolean> {
+    return { ...this.writes };
+  }
+
+  // Keeps the last known toggles while the store is unreachable
+  async refresh(): Promise<void> {
+    if (!this.store) return;
+    try {
+      const stored = await this.store.get<Partial<Record<Signal, unknown>>>(STORE_KEY);
+      if (stored) this.apply(stored);
+    } catch (error) {
+      logThrottledError('signal-toggles-refresh', 'Failed to read OTEL signal toggles', { error });
+    }
+  }
+
+  // Applies boolean entries of changes, e.g. { logs: false }, and publishes the result
+  async update(changes: Partial<Record<Signal, unknown>>): Promise<Record<Signal, boolean>> {
+    await this.refresh();
+    this.apply(changes);
+    if (this.store) {
+      await this.store.set(STORE_KEY, this.writes);
+    }
+    return this.current();
+  }
+
+  private apply(changes: Partial<Record<Signal, unknown>>): void {
+    (Object.keys(this.writes) as Signal[]).forEach((signal) => {
+      const value = changes[signal];
+      if (typeof value === 'boolean') {
+        this.writes[signal] = value;
+      }
+    });
+  }
+}
@@ -0,0 +1,99 @@
+import { describe, it, mock } from 'node:test';
+import assert from 'node:assert/strict';
+import { promises as fs } from 'fs';
+import os from 'os';
+import path from 'path';
+import { gunzipSync } from 'zlib';
+import { logger } from '../utils/logger';
+import { clickHouseService } from './clickhouseService';
+import {
+  ClickHouseSink,
+  createSink,
+  DryRunSink,
+  FileSink,
+  GzipBatchSink,
+  NoopSink,
+  PerSignalSink,
+} from './telemetrySink';
+
+describe('FileSink', () => {
+  it('appends one JSON line per record to <signal>.ndjson', async () => {
+    const directory = await fs.mkdtemp(path.join(os.tmpdir(), 'appsentry-sink-'));
+    try {
+      const sink = new FileSink(directory);
+      await sink.writeTraces([{ TraceId: 'a', SpanAttributes: { 'http.route': '/' } }]);
+      await sink.writeTraces([{ TraceId: 'b' }]);
+      await sink.writeLogs([]);
+
+      assert.equal(
+        await fs.readFile(path.join(directory, 'traces.ndjson'), 'utf8'),
+        '{"TraceId":"a","SpanAttributes":{"http.route":"/"}}\n{"TraceId":"b"}\n',
+      );
+      assert.deepEqual(await fs.readdir(directory), ['traces.ndjson']);
+    } finally {
+      await fs.rm(directory, { recursive: true, force: true });
+    }
+  });
+});
+
+describe('DryRunSink', () => {
+  it('logs what would be written without touching ClickHouse', async () => {
+    const insert = mock.method(clickHouseService, 'insertTraces', async () => {});
+    const info = mock.method(logger, 'info', () => logger);
+    try {
+      await new DryRunSink().writeTraces([{ TraceId: 'a' }, { TraceId: 'b' }]);
+
+      assert.equal(insert.mock.callCount(), 0);
+      assert.equal(info.mock.calls[0].arguments[0], 'Dry run: would write 2 traces');
+    } finally {
+      insert.mock.restore();
+      info.mock.restore();
+    }
+  });
+});
+
+describe('NoopSink', () => {
+  it('accepts every signal without writing anywhere', async () => {
+    const insert = mock.method(clickHouseService, 'insertTraces', async () => {});
+    try {
+      const sink = createSink('noop');
+      assert.ok(sink instanceof NoopSink);
+      await sink.writeTraces([{ TraceId: 'a' }]);
+      await sink.writeMetrics([{ MetricName: 'requests' }]);
+      await sink.writeLogs([{ Body: 'x' }]);
+      await sink.writeEvents([{ Body: 'y' }]);
+      assert.equal(insert.mock.callCount(), 0);
+    } finally {
+      insert.mock.restore();
+    }
+  });
+});
+
+describe('PerSignalSink', () => {
+  it('sends logs to gzipped batches and traces to ClickHouse', async () => {
+    const directory = await fs.mkdtemp(path.join(os.tmpdir(), 'appsentry-sink-'));
+    const insertTraces = mock.method(clickHouseService, 'insertTraces', async () => {});
+    const insertLogs = mock.method(clickHouseService, 'insertLogs', async () => {});
+    try {
+      const clickhouse = new ClickHouseSink();
+      const batches = new GzipBatchSink(directory);
+      const sink = new PerSignalSink({ traces: clickhouse, metrics: clickhouse, logs: batches, events: clickhouse });
+
+      await sink.writeTraces([{ TraceId: 'a' }]);
+      await sink.writeLogs([{ Body: 'first' }, { Body: 'second' }]);
+
+      assert.deepEqual(insertTraces.mock.calls[0].arguments, [[{ TraceId: 'a' }]]);
+      assert.equal(insertLogs.mock.callCount(), 0);
+      const [file] = await fs.readdir(path.join(directory, 'logs'));
+      assert.match(file, /\.ndjson\.gz$/);
+      assert.equal(
+        gunzipSync(await fs.readFile(path.join(directory, 'logs', file))).toString(),
+        '{"Body":"first"}\n{"Body":"second"}\n',
+      );
+    } finally {
+      insertTraces.mock.restore();
+      insertLogs.mock.restore();
+      await fs.rm(directory, { recursive: true, force: true });
+    }
+  });
+});
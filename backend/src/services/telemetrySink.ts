@@ -0,0 +1,297 @@
+import { BlobServiceClient, ContainerClient } from '@azure/storage-blob';
+import { randomUUID } from 'crypto';
+import { promises as fs } from 'fs';
+import path from 'path';
+import { promisify } from 'util';
+import { gzip } from 'zlib';
+import { config } from '../config/env';
+import { logger } from '../utils/logger';
+import { clickHouseService } from './clickhouseService';
+
+export type TelemetrySignal = 'traces' | 'metrics' | 'logs' | 'events';
+
+export interface TelemetrySink {
+  writeTraces(records: any[]): Promise<void>;
+  writeMetrics(records: any[]): Promise<void>;
+  writeLogs(records: any[]): Promise<void>;
+  writeEvents(records: any[]): Promise<void>;
+}
+
+const toNdjson = (records: any[]): string => records.map((record) => JSON.stringify(record)).join('\n') + '\n';
+
+const gzipAsync = promisify(gzip);
+
+// Timestamped and unique, so concurrent batches never overwrite each other
+const batchFileName = (): string =>
+  `${new Date().toISOString().replace(/[:.]/g, '-')}-${randomUUID()}.ndjson.gz`;
+
+export class ClickHouseSink implements TelemetrySink {
+  async writeTraces(records: any[]): Promise<void> {
+    await clickHouseService.insertTraces(records);
+  }
+
+  async writeMetrics(records: any[]): Promise<void> {
+    await clickHouseService.insertMetrics(records);
+  }
+
+  async writeLogs(records: any[]): Promise<void> {
+    await clickHouseService.insertLogs(records);
+  }
+
+  async writeEvents(records: any[]): Promise<void> {
+    await clickHouseService.insertEvents(records);
+  }
+}
+
+// Appends newline-delimited JSON to <directory>/<signal>.ndjson
+export class FileSink implements TelemetrySink {
+  private directory: string;
+
+  constructor(directory: string) {
+    this.directory = directory;
+  }
+
+  async writeTraces(records: any[]): Promise<void> {
+    await this.write('traces', records);
+  }
+
+  async writeMetrics(records: any[]): Promise<void> {
+    await this.write('metrics', records);
+  }
+
+  async writeLogs(records: any[]): Promise<void> {
+    await this.write('logs', records);
+  }
+
+  async writeEvents(records: any[]): Promise<void> {
+    await this.write('events', records);
+  }
+
+  private async write(signal: TelemetrySignal, records: any[]): Promise<void> {
+    if (records.length === 0) return;
+
+    await fs.mkdir(this.directory, { recursive: true });
+    await fs.appendFile(path.join(this.directory, `${signal}.ndjson`), toNdjson(records));
+  }
+}
+
+// Writes each batch as its own gzipped NDJSON file under <directory>/<signal>/ on local disk
+export class GzipBatchSink implements TelemetrySink {
+  private directory: string;
+
+  constructor(directory: string) {
+    this.directory = directory;
+  }
+
+  async writeTraces(records: any[]): Promise<void> {
+    await this.write('traces', records);
+  }
+
+  async writeMetrics(records: any[]): Promise<void> {
+    await this.write('metrics', records);
+  }
+
+  async writeLogs(records: any[]): Promise<void> {
+    await this.write('logs', records);
+  }
+
+  async writeEvents(records: any[]): Promise<void> {
+    await this.write('events', records);
+  }
+
+  private async write(signal: TelemetrySignal, records: any[]): Promise<void> {
+    if (records.length === 0) return;
+
+    const directory = path.join(this.directory, signal);
+    await fs.mkdir(directory, { recursive: true });
+    await fs.writeFile(path.join(directory, batchFileName()), await gzipAsync(toNdjson(records)));
+  }
+}
+
+// Uploads each batch as a gzipped NDJSON blob named <signal>/<yyyy-mm-dd>/<batch>, using the
+// same storage account as test artifacts
+export class BlobSink implements TelemetrySink {
+  private containerClient: ContainerClient;
+  private containerReady: Promise<unknown> | null = null;
+
+  constructor(container: string) {
+    const connectionString = `DefaultEndpointsProtocol=https;AccountName=${config.azureStorage.account};AccountKey=${config.azureStorage.key};EndpointSuffix=core.windows.net`;
+    this.containerClient = BlobServiceClient.fromConnectionString(connectionString).getContainerClient(container);
+  }
+
+  async writeTraces(records: any[]): Promise<void> {
+    await this.write('traces', records);
+  }
+
+  async writeMetrics(records: any[]): Promise<void> {
+    await this.write('metrics', records);
+  }
+
+  async writeLogs(records: any[]): Promise<void> {
+    await this.write('logs', records);
+  }
+
+  async writeEvents(records: any[]): Promise<void> {
+    await this.write('events', records);
+  }
+
+  // Created on first write; a failed attempt is retried by the next batch
+  private ensureContainer(): Promise<unknown> {
+    if (!this.containerReady) {
+      this.containerReady = this.containerClient.createIfNotExists().catch((error) => {
+        this.containerReady = null;
+        throw error;
+      });
+    }
+    return this.containerReady;
+  }
+
+  private async write(signal: TelemetrySignal, records: any[]): Promise<void> {
+    if (records.length === 0) return;
+
+    await this.ensureContainer();
+    const blobName = `${signal}/${new Date().toISOString().slice(0, 10)}/${batchFileName()}`;
+    await this.containerClient.getBlockBlobClient(blobName).uploadData(await gzipAsync(toNdjson(records)), {
+      blobHTTPHeaders: {
+        blobContentType: 'application/x-ndjson',
+        blobContentEncoding: 'gzip',
+      },
+    });
+  }
+}
+
+export class StdoutSink implements TelemetrySink {
+  async writeTraces(records: any[]): Promise<void> {
+    this.write(records);
+  }
+
+  async writeMetrics(records: any[]): Promise<void> {
+    this.write(records);
+  }
+
+  async writeLogs(records: any[]): Promise<void> {
+    this.write(records);
+  }
+
+  async writeEvents(records: any[]): Promise<void> {
+    this.write(records);
+  }
+
+  private write(records: any[]): void {
+    if (records.length === 0) return;
+    process.stdout.write(toNdjson(records));
+  }
+}
+
+// Accepts and discards everything, for measuring ingestion throughput without storage cost
+export class NoopSink implements TelemetrySink {
+  async writeTraces(): Promise<void> {}
+
+  async writeMetrics(): Promise<void> {}
+
+  async writeLogs(): Promise<void> {}
+
+  async writeEvents(): Promise<void> {}
+}
+
+// Processes everything but only logs what would have been written
+export class DryRunSink implements TelemetrySink {
+  async writeTraces(records: any[]): Promise<void> {
+    this.write('traces', records);
+  }
+
+  async writeMetrics(records: any[]): Promise<void> {
+    this.write('metrics', records);
+  }
+
+  async writeLogs(records: any[]): Promise<void> {
+    this.write('logs', records);
+  }
+
+  async writeEvents(records: any[]): Promise<void> {
+    this.write('events', records);
+  }
+
+  private write(signal: TelemetrySignal, records: any[]): void {
+    if (records.length === 0) return;
+    logger.info(`Dry run: would write ${records.length} ${signal}`, { sample: records[0] });
+  }
+}
+
+// Sends each signal to its own sink, e.g. traces to ClickHouse and logs to blob storage
+export class PerSignalSink implements TelemetrySink {
+  private sinks: Record<TelemetrySignal, TelemetrySink>;
+
+  constructor(sinks: Record<TelemetrySignal, TelemetrySink>) {
+    this.sinks = sinks;
+  }
+
+  async writeTraces(records: any[]): Promise<void> {
+    await this.sinks.traces.writeTraces(records);
+  }
+
+  async writeMetrics(records: any[]): Promise<void> {
+    await this.sinks.metrics.writeMetrics(records);
+  }
+
+  async writeLogs(records: any[]): Promise<void> {
+    await this.sinks.logs.writeLogs(records);
+  }
+
+  async writeEvents(records: any[]): Promise<void> {
+    await this.sinks.events.writeEvents(records);
+  }
+}
+
+export const createSink = (kind: string): TelemetrySink => {
+  switch (kind) {
+    case 'file':
+      return new FileSink(process.env.OTEL_SINK_FILE_DIR || 'telemetry');
+    case 'gzip':
+      return new GzipBatchSink(process.env.OTEL_SINK_GZIP_DIR || 'telemetry-batches');
+    case 'blob':
+      return new BlobSink(process.env.OTEL_SINK_BLOB_CONTAINER || 'telemetry');
+    case 'stdout':
+      return new StdoutSink();
+    case 'noop':
+      return new NoopSink();
+    case 'clickhouse':
+      return new ClickHouseSink();
+    default:
+      logger.warn(`Unknown OTEL_SINK "${kind}", falling back to ClickHouse`);
+      return new ClickHouseSink();
+  }
+};
+
+// OTEL_<SIGNAL>_SINK overrides OTEL_SINK for a single signal
+const defaultKind = process.env.OTEL_SINK || 'clickhouse';
+const signals: TelemetrySignal[] = ['traces', 'metrics', 'logs', 'events'];
+const kinds = signals.map((signal) => process.env[`OTEL_${signal.toUpperCase()}_SINK`] || defaultKind);
+
+export const sinkSettings = {
+  dryRun: process.env.DRY_RUN === 'true',
+  ...Object.fromEntries(signals.map((signal, i) => [signal, kinds[i]])),
+  fileDirectory: process.env.OTEL_SINK_FILE_DIR || 'telemetry',
+  gzipDirectory: process.env.OTEL_SINK_GZIP_DIR || 'telemetry-batches',
+  blobContainer: process.env.OTEL_SINK_BLOB_CONTAINER || 'telemetry',
+};
+
+const createConfiguredSink = (): TelemetrySink => {
+  if (kinds.every((kind) => kind === defaultKind)) return createSink(defaultKind);
+
+  // Signals sharing a kind share one sink instance
+  const byKind = new Map<string, TelemetrySink>();
+  const sinkFor = (kind: string): TelemetrySink => {
+    if (!byKind.has(kind)) byKind.set(kind, createSink(kind));
+    return byKind.get(kind)!;
+  };
+  logger.info('Using per-signal telemetry sinks', sinkSettings);
+  return new PerSignalSink({
+    traces: sinkFor(kinds[0]),
+    metrics: sinkFor(kinds[1]),
+    logs: sinkFor(kinds[2]),
+    events: sinkFor(kinds[3]),
+  });
+};
+
+export const telemetrySink = process.env.DRY_RUN === 'true' ? new DryRunSink() : createConfiguredSink();
@@ -0,0 +1,26 @@
+// Preloaded by `npm test`. config/env validates these at import, so unit tests get
+// placeholders instead of needing a real .env; anything already set is kept.
+const placeholders: Record<string, string> = {
+  NODE_ENV: 'test',
+  CORS_ORIGIN: 'http://localhost:3000',
+  MYSQL_HOST: 'localhost',
+  MYSQL_DATABASE: 'appsentry_test',
+  MYSQL_USERNAME: 'test',
+  MYSQL_PASSWORD: 'test',
+  REDIS_URL: 'redis://localhost:6379',
+  REDIS_ENABLED: 'false',
+  AZURE_AD_TENANT_ID: 'test',
+  AZURE_AD_CLIENT_ID: 'test',
+  AZURE_AD_CLIENT_SECRET: 'test',
+  AZURE_AD_REDIRECT_URI: 'http://localhost:3000/auth/callback',
+  JWT_SECRET: 'test',
+  AZURE_STORAGE_ACCOUNT: 'test',
+  AZURE_STORAGE_KEY: 'test',
+  HEALTH_CHECK_PASSWORD: 'test',
+};
+
+Object.entries(placeholders).forEach(([name, value]) => {
+  if (process.env[name] === undefined) {
+    process.env[name] = value;
+  }
+});
@@ -0,0 +1,63 @@
+import { describe, it } from 'node:test';
+import assert from 'node:assert/strict';
+import { CircuitBreaker, CircuitOpenError } from './circuitBreaker';
+
+const sleep = (ms: number) => new Promise((resolve) => setTimeout(resolve, ms));
+const fail = () => Promise.reject(new Error('backend down'));
+
+describe('CircuitBreaker', () => {
+  it('opens after the failure threshold and rejects without calling the task', async () => {
+    const breaker = new CircuitBreaker('test', 2, 60000);
+    await assert.rejects(breaker.run(fail), /backend down/);
+    assert.equal(breaker.getState(), 'closed');
+    await assert.rejects(breaker.run(fail), /backend down/);
+    assert.equal(breaker.getState(), 'open');
+
+    let called = false;
+    const error = await breaker
+      .run(async () => {
+        called = true;
+      })
+      .catch((e) => e);
+    assert.ok(error instanceof CircuitOpenError);
+    assert.ok(error.retryAfterMs > 0 && error.retryAfterMs <= 60000);
+    assert.equal(called, false);
+  });
+
+  it('lets a single probe through after the cooldown and closes when it succeeds', async () => {
+    const breaker = new CircuitBreaker('test', 1, 10);
+    await assert.rejects(breaker.run(fail));
+    await sleep(20);
+
+    let finishProbe!: () => void;
+    const probe = breaker.run(() => new Promise<void>((resolve) => (finishProbe = resolve)));
+    assert.equal(breaker.getState(), 'half-open');
+    await assert.rejects(breaker.run(async () => undefined), CircuitOpenError);
+
+    finishProbe();
+    await probe;
+    assert.equal(breaker.getState(), 'closed');
+  });
+
+  it('reopens when the probe fails', async () => {
+    const breaker = new CircuitBreaker('test', 1, 10);
+    await assert.rejects(breaker.run(fail));
+    await sleep(20);
+    await assert.rejects(breaker.run(fail), /backend down/);
+    assert.equal(breaker.getState(), 'open');
+  });
+
+  it('ignores errors rejected by isFailure', async () => {
+    const breaker = new CircuitBreaker('test', 1, 60000, (error) => !/too large/.test(String(error)));
+    await assert.rejects(breaker.run(() => Promise.reject(new Error('batch too large'))));
+    assert.equal(breaker.getState(), 'closed');
+  });
+
+  it('is disabled with a zero threshold', async () => {
+    const breaker = new CircuitBreaker('test', 0, 60000);
+    for (let i = 0; i < 5; i++) {
+      await assert.rejects(breaker.run(fail), /backend down/);
+    }
+    assert.equal(breaker.getState(), 'closed');
+  });
+});
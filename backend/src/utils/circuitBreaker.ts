@@ -0,0 +1,90 @@
+export type CircuitState = 'closed' | 'open' | 'half-open';
+
+export class CircuitOpenError extends Error {
+  // Time until the breaker lets a probe through, 0 when a probe is already running
+  retryAfterMs: number;
+
+  constructor(name: string, retryAfterMs = 0) {
+    super(`Circuit ${name} is open`);
+    this.name = 'CircuitOpenError';
+    this.retryAfterMs = retryAfterMs;
+  }
+}
+
+// Short-circuits calls after repeated failures, then lets a single probe through after the cooldown.
+// Errors rejected by isFailure (e.g. bad input rather than an unhealthy backend) don't count.
+export class CircuitBreaker {
+  private name: string;
+  private failureThreshold: number;
+  private cooldownMs: number;
+  private isFailure: (error: unknown) => boolean;
+  private state: CircuitState = 'closed';
+  private failures = 0;
+  private openedAt = 0;
+  private probeInFlight = false;
+
+  constructor(
+    name: string,
+    failureThreshold: number,
+    cooldownMs: number,
+    isFailure: (error: unknown) => boolean = () => true,
+  ) {
+    this.name = name;
+    this.failureThreshold = failureThreshold;
+    this.cooldownMs = cooldownMs;
+    this.isFailure = isFailure;
+  }
+
+  getState(): CircuitState {
+    return this.state;
+  }
+
+  async run<T>(task: () => Promise<T>): Promise<T> {
+    if (this.failureThreshold <= 0) {
+      return task();
+    }
+
+    if (this.state === 'open') {
+      const remainingMs = this.openedAt + this.cooldownMs - Date.now();
+      if (remainingMs > 0) {
+        throw new CircuitOpenError(this.name, remainingMs);
+      }
+      this.state = 'half-open';
+    }
+
+    if (this.state === 'half-open') {
+      if (this.probeInFlight) {
+        throw new CircuitOpenError(this.name);
+      }
+      this.probeInFlight = true;
+    }
+
+    try {
+      const result = await task();
+      this.onSuccess();
+      return result;
+    } catch (error) {
+      if (this.isFailure(error)) {
+        this.onFailure();
+      } else {
+        this.probeInFlight = false;
+      }
+      throw error;
+    }
+  }
+
+  private onSuccess(): void {
+    this.state = 'closed';
+    this.failures = 0;
+    this.probeInFlight = false;
+  }
+
+  private onFailure(): void {
+    this.failures++;
+    if (this.state === 'half-open' || this.failures >= this.failureThreshold) {
+      this.state = 'open';
+      this.openedAt = Date.now();
+    }
+    this.probeInFlight = false;
+  }
+}
@@ -0,0 +1,46 @@
+import { describe, it } from 'node:test';
+import assert from 'node:assert/strict';
+import { DeltaToCumulative } from './deltaToCumulative';
+
+const point = (value: number, start: number, end: number) => ({
+  value,
+  startTimeUnixNano: BigInt(start),
+  timeUnixNano: BigInt(end),
+});
+
+describe('DeltaToCumulative', () => {
+  it('accumulates consecutive deltas from the first start time', () => {
+    const converter = new DeltaToCumulative(60000);
+    assert.deepEqual(converter.convert('a', point(5, 0, 10), true), point(5, 0, 10));
+    assert.deepEqual(converter.convert('a', point(3, 10, 20), true), point(8, 0, 20));
+    assert.deepEqual(converter.convert('a', point(2, 25, 30), true), point(10, 0, 30));
+  });
+
+  it('keeps series apart', () => {
+    const converter = new DeltaToCumulative(60000);
+    converter.convert('a', point(5, 0, 10), true);
+    assert.deepEqual(converter.convert('b', point(1, 0, 10), true), point(1, 0, 10));
+  });
+
+  it('drops resent and late deltas instead of resetting', () => {
+    const converter = new DeltaToCumulative(60000);
+    converter.convert('a', point(5, 0, 10), true);
+    converter.convert('a', point(3, 10, 20), true);
+    assert.equal(converter.convert('a', point(3, 10, 20), true), undefined);
+    assert.equal(converter.convert('a', point(1, 5, 10), true), undefined);
+    assert.deepEqual(converter.convert('a', point(1, 20, 30), true), point(9, 0, 30));
+  });
+
+  it('resets a monotonic sum on a negative delta', () => {
+    const converter = new DeltaToCumulative(60000);
+    converter.convert('a', point(5, 0, 10), true);
+    assert.deepEqual(converter.convert('a', point(-2, 10, 20), true), point(0, 10, 20));
+    assert.deepEqual(converter.convert('a', point(4, 20, 30), true), point(4, 10, 30));
+  });
+
+  it('adds negative deltas to non-monotonic sums', () => {
+    const converter = new DeltaToCumulative(60000);
+    converter.convert('a', point(5, 0, 10), false);
+    assert.deepEqual(converter.convert('a', point(-2, 10, 20), false), point(3, 0, 20));
+  });
+});
@@ -0,0 +1,65 @@
+export interface SumPoint {
+  value: number;
+  startTimeUnixNano: bigint;
+  timeUnixNano: bigint;
+}
+
+interface SeriesState extends SumPoint {
+  updatedAt: number;
+}
+
+// Running totals per series for turning delta sums into cumulative ones.
+// Series idle longer than ttlMs are forgotten so memory stays bounded.
+// Callers should feed each series oldest first and without duplicates.
+export class DeltaToCumulative {
+  private ttlMs: number;
+  private series = new Map<string, SeriesState>();
+  private lastSweep = Date.now();
+
+  constructor(ttlMs: number) {
+    this.ttlMs = ttlMs;
+  }
+
+  // Returns undefined for a delta starting before the series' last one ended: a late or resent
+  // point can't be added without double counting or rewriting totals already stored
+  convert(seriesKey: string, delta: SumPoint, monotonic: boolean): SumPoint | undefined {
+    this.sweep();
+
+    const state = this.series.get(seriesKey);
+    if (state && delta.startTimeUnixNano < state.timeUnixNano) {
+      return undefined;
+    }
+
+    // A negative monotonic delta means the producer's counter was reset
+    const reset = !state || (monotonic && delta.value < 0);
+
+    const next: SeriesState = reset
+      ? {
+          value: monotonic && delta.value < 0 ? 0 : delta.value,
+          startTimeUnixNano: delta.startTimeUnixNano,
+          timeUnixNano: delta.timeUnixNano,
+          updatedAt: Date.now(),
+        }
+      : {
+          value: state.value + delta.value,
+          startTimeUnixNano: state.startTimeUnixNano,
+          timeUnixNano: delta.timeUnixNano,
+          updatedAt: Date.now(),
+        };
+
+    this.series.set(seriesKey, next);
+    return { value: next.value, startTimeUnixNano: next.startTimeUnixNano, timeUnixNano: next.timeUnixNano };
+  }
+
+  private sweep(): void {
+    const now = Date.now();
+    if (now - this.lastSweep < this.ttlMs) return;
+
+    this.lastSweep = now;
+    this.series.forEach((state, key) => {
+      if (now - state.updatedAt > this.ttlMs) {
+        this.series.delete(key);
+      }
+    });
+  }
+}
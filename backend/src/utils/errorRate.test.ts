@@ -0,0 +1,36 @@
+import { describe, it } from 'node:test';
+import assert from 'node:assert/strict';
+import { RollingErrorRate } from './errorRate';
+import { CircuitOpenError } from './circuitBreaker';
+import { isRetryableWriteError } from '../services/clickhouseService';
+
+const recordRequests = (errorRate: RollingErrorRate, succeeded: number, failures: unknown[]) => {
+  for (let i = 0; i < succeeded; i++) errorRate.record(false);
+  failures.forEach((error) => errorRate.recordFailure(error));
+};
+
+describe('RollingErrorRate readiness', () => {
+  it('reports 503 once the failure rate passes the threshold', () => {
+    const errorRate = new RollingErrorRate(60000, isRetryableWriteError);
+    recordRequests(errorRate, 5, [new Error('bad row'), new Error('bad row'), new Error('bad row')]);
+    assert.equal(errorRate.readiness(0.5, 10).statusCode, 200);
+
+    recordRequests(errorRate, 0, [new Error('bad row'), new Error('bad row'), new Error('bad row')]);
+    assert.deepEqual(errorRate.readiness(0.5, 10), { statusCode: 503, status: 'degraded', rate: 6 / 11 });
+  });
+
+  it('stays healthy below the minimum sample count', () => {
+    const errorRate = new RollingErrorRate(60000);
+    recordRequests(errorRate, 0, [new Error('a'), new Error('b')]);
+    assert.equal(errorRate.readiness(0.5, 10).statusCode, 200);
+  });
+
+  it('does not count retryable storage failures', () => {
+    const errorRate = new RollingErrorRate(60000, isRetryableWriteError);
+    const outage = Array.from({ length: 20 }, () => new CircuitOpenError('clickhouse-writes', 30000));
+    recordRequests(errorRate, 5, outage);
+
+    assert.deepEqual(errorRate.snapshot(), { total: 5, errors: 0, rate: 0 });
+    assert.equal(errorRate.readiness(0.5, 1).statusCode, 200);
+  });
+});
@@ -0,0 +1,50 @@
+// Error rate over a rolling window, tracked in one-second buckets to bound memory.
+// Failures matching isIgnored are left out of both the errors and the total.
+export class RollingErrorRate {
+  private windowMs: number;
+  private isIgnored: (error: unknown) => boolean;
+  private buckets = new Map<number, { total: number; errors: number }>();
+
+  constructor(windowMs: number, isIgnored: (error: unknown) => boolean = () => false) {
+    this.windowMs = windowMs;
+    this.isIgnored = isIgnored;
+  }
+
+  record(failed: boolean): void {
+    const second = Math.floor(Date.now() / 1000);
+    const bucket = this.buckets.get(second) || { total: 0, errors: 0 };
+    bucket.total++;
+    if (failed) bucket.errors++;
+    this.buckets.set(second, bucket);
+  }
+
+  recordFailure(error: unknown): void {
+    if (!this.isIgnored(error)) {
+      this.record(true);
+    }
+  }
+
+  snapshot(): { total: number; errors: number; rate: number } {
+    const oldest = Math.floor((Date.now() - this.windowMs) / 1000);
+    let total = 0;
+    let errors = 0;
+
+    this.buckets.forEach((bucket, second) => {
+      if (second < oldest) {
+        this.buckets.delete(second);
+        return;
+      }
+      total += bucket.total;
+      errors += bucket.errors;
+    });
+
+    return { total, errors, rate: total > 0 ? errors / total : 0 };
+  }
+
+  // Degraded (503) once at least minSamples requests in the window were seen and more than maxRate failed
+  readiness(maxRate: number, minSamples: number): { statusCode: 200 | 503; status: string; rate: number } {
+    const { total, rate } = this.snapshot();
+    const degraded = total >= minSamples && rate > maxRate;
+    return { statusCode: degraded ? 503 : 200, status: degraded ? 'degraded' : 'healthy', rate };
+  }
+}
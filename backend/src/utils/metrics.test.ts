@@ -0,0 +1,130 @@
+import { describe, it } from 'node:test';
+import assert from 'node:assert/strict';
+import { MeterProvider, ObservableCallback } from '@opentelemetry/api';
+import { AppMeter, loadMetricsConfig } from './metrics';
+
+interface Created {
+  kind: string;
+  name: string;
+  options?: any;
+  values: Array<[number, any]>;
+}
+
+// Records instruments and the values reported through them
+const recordingProvider = () => {
+  const created: Created[] = [];
+  const instrument = (kind: string) => (name: string, options?: any) => {
+    const entry: Created = { kind, name, options, values: [] };
+    created.push(entry);
+    return {
+      add: (value: number, attributes?: any) => entry.values.push([value, attributes]),
+      record: (value: number, attributes?: any) => entry.values.push([value, attributes]),
+      addCallback: () => undefined,
+      removeCallback: () => undefined,
+    };
+  };
+  const provider = {
+    getMeter: () => ({
+      createCounter: instrument('counter'),
+      createUpDownCounter: instrument('updown'),
+      createHistogram: instrument('histogram'),
+      createObservableGauge: instrument('gauge'),
+    }),
+  } as unknown as MeterProvider;
+  return { provider, created };
+};
+
+describe('AppMeter histogram buckets', () => {
+  it('registers histograms with the configured buckets', () => {
+    const { provider, created } = recordingProvider();
+    const config = loadMetricsConfig({
+      METRICS_HISTOGRAM_BUCKETS: '{"application_request_duration_ms":[5,25,100,500,2000]}',
+    });
+    const meter = new AppMeter('test', '1.0.0', config, provider);
+
+    meter.createHistogram('application_request_duration_ms', { description: 'Request duration', unit: 'ms' });
+    assert.deepEqual(created[0].options, {
+      description: 'Request duration',
+      unit: 'ms',
+      advice: { explicitBucketBoundaries: [5, 25, 100, 500, 2000] },
+    });
+  });
+
+  it('keeps the instrument\'s own advice when no buckets are configured', () => {
+    const { provider, created } = recordingProvider();
+    const meter = new AppMeter('test', '1.0.0', loadMetricsConfig({}), provider);
+
+    meter.createHistogram('batch_bytes', { advice: { explicitBucketBoundaries: [1024, 4096] } });
+    assert.deepEqual(created[0].options.advice, { explicitBucketBoundaries: [1024, 4096] });
+  });
+
+  it('ignores bucket lists that are not ascending numbers', () => {
+    const config = loadMetricsConfig({ METRICS_HISTOGRAM_BUCKETS: '{"a":[10,5],"b":["1"],"c":[1,2]}' });
+    assert.deepEqual(config.histogramBuckets, { c: [1, 2] });
+  });
+});
+
+describe('AppMeter namespace', () => {
+  it('prefixes instrument names with a custom namespace', () => {
+    const { provider, created } = recordingProvider();
+    const meter = new AppMeter('test', '1.0.0', loadMetricsConfig({ METRICS_NAMESPACE: 'appsentry_staging' }), provider);
+
+    meter.createCounter(meter.namespaced('invalid_records_total'));
+    assert.equal(created[0].name, 'appsentry_staging_invalid_records_total');
+  });
+
+  it('defaults to appsentry and rejects names Prometheus would not accept', () => {
+    assert.equal(loadMetricsConfig({}).namespace, 'appsentry');
+    assert.equal(loadMetricsConfig({ METRICS_NAMESPACE: 'app-sentry' }).namespace, 'appsentry');
+  });
+});
+
+describe('AppMeter const labels', () => {
+  const config = loadMetricsConfig({ METRICS_CONST_LABELS: 'region=westeurope, cluster=aks-prod' });
+
+  it('adds the labels to every recorded value', () => {
+    const { provider, created } = recordingProvider();
+    const meter = new AppMeter('test', '1.0.0', config, provider);
+
+    meter.createCounter('requests_total').add(1, { route: '/api' });
+    meter.createUpDownCounter('connections').add(1);
+    meter.createHistogram('duration_ms').record(12, { region: 'spoofed' });
+
+    assert.deepEqual(
+      created.map((entry) => entry.values[0][1]),
+      [
+        { route: '/api', region: 'westeurope', cluster: 'aks-prod' },
+        { region: 'westeurope', cluster: 'aks-prod' },
+        { region: 'westeurope', cluster: 'aks-prod' },
+      ],
+    );
+  });
+
+  it('adds the labels to observable gauge readings', () => {
+    const observed: Array<[number, any]> = [];
+    let registered: ObservableCallback | undefined;
+    const provider = {
+      getMeter: () => ({
+        createObservableGauge: () => ({
+          addCallback: (callback: ObservableCallback) => (registered = callback),
+          removeCallback: () => (registered = undefined),
+        }),
+      }),
+    } as unknown as MeterProvider;
+
+    const gauge = new AppMeter('test', '1.0.0', config, provider).createObservableGauge('last_seen');
+    const callback: ObservableCallback = (result) => result.observe(30, { service: 'checkout' });
+    gauge.addCallback(callback);
+    registered!({ observe: (value: number, attributes: any) => observed.push([value, attributes]) });
+    assert.deepEqual(observed, [[30, { service: 'checkout', region: 'westeurope', cluster: 'aks-prod' }]]);
+
+    gauge.removeCallback(callback);
+    assert.equal(registered, undefined);
+  });
+
+  it('skips malformed entries', () => {
+    assert.deepEqual(loadMetricsConfig({ METRICS_CONST_LABELS: 'region=eu,=x,bad-name=y,zone' }).constLabels, {
+      region: 'eu',
+    });
+  });
+});
@@ -0,0 +1,164 @@
+import {
+  metrics,
+  Attributes,
+  Counter,
+  Histogram,
+  Meter,
+  MeterProvider,
+  MetricOptions,
+  ObservableCallback,
+  ObservableGauge,
+  UpDownCounter,
+} from '@opentelemetry/api';
+import { logger } from './logger';
+
+export interface MetricsConfig {
+  // Prefix for this backend's own appsentry_* instruments, so several AppSentry
+  // deployments can scrape into one Prometheus without colliding
+  namespace: string;
+  // Labels added to every recorded value, e.g. region=westeurope,cluster=aks-prod, so a
+  // fleet's metrics stay apart in a central Prometheus
+  constLabels: Attributes;
+  // Bucket boundaries per full histogram name, replacing the instrument's defaults,
+  // e.g. {"application_request_duration_ms":[5,25,100,500,2000]}
+  histogramBuckets: Record<string, number[]>;
+}
+
+const isAscending = (values: unknown): values is number[] =>
+  Array.isArray(values) &&
+  values.length > 0 &&
+  values.every((value, index) => Number.isFinite(value) && (index === 0 || value > values[index - 1]));
+
+const parseHistogramBuckets = (raw?: string): Record<string, number[]> => {
+  if (!raw) return {};
+  try {
+    const parsed = JSON.parse(raw) as Record<string, unknown>;
+    return Object.fromEntries(
+      Object.entries(parsed).filter(([name, buckets]) => {
+        if (isAscending(buckets)) return true;
+        logger.warn(`Ignoring METRICS_HISTOGRAM_BUCKETS for ${name}: expected ascending numbers`);
+        return false;
+      }),
+    ) as Record<string, number[]>;
+  } catch (error) {
+    logger.warn('Ignoring invalid JSON in METRICS_HISTOGRAM_BUCKETS', { error });
+    return {};
+  }
+};
+
+// Prometheus metric names allow letters, digits, underscores and colons, not starting with a digit
+const METRIC_NAMESPACE_PATTERN = /^[a-zA-Z_:][a-zA-Z0-9_:]*$/;
+
+const parseNamespace = (raw?: string): string => {
+  if (!raw) return 'appsentry';
+  if (!METRIC_NAMESPACE_PATTERN.test(raw)) {
+    logger.warn(`Ignoring invalid METRICS_NAMESPACE="${raw}", using appsentry`);
+    return 'appsentry';
+  }
+  return raw;
+};
+
+// Prometheus label names allow letters, digits and underscores, not starting with a digit
+const LABEL_NAME_PATTERN = /^[a-zA-Z_][a-zA-Z0-9_]*$/;
+
+const parseConstLabels = (raw?: string): Attributes => {
+  const labels: Attributes = {};
+  (raw || '')
+    .split(',')
+    .map((pair) => pair.trim())
+    .filter(Boolean)
+    .forEach((pair) => {
+      const separator = pair.indexOf('=');
+      const key = pair.slice(0, separator).trim();
+      const value = pair.slice(separator + 1).trim();
+      if (separator > 0 && value && LABEL_NAME_PATTERN.test(key)) {
+        labels[key] = value;
+      } else {
+        logger.warn(`Ignoring invalid METRICS_CONST_LABELS entry "${pair}"`);
+      }
+    });
+  return labels;
+};
+
+export const loadMetricsConfig = (env: NodeJS.ProcessEnv = process.env): MetricsConfig => ({
+  namespace: parseNamespace(env.METRICS_NAMESPACE),
+  constLabels: parseConstLabels(env.METRICS_CONST_LABELS),
+  histogramBuckets: parseHistogramBuckets(env.METRICS_HISTOGRAM_BUCKETS),
+});
+
+export const metricsConfig = loadMetricsConfig();
+
+// A meter whose instruments follow the METRICS_* settings. It binds to the provider at
+// construction, so create it after otel.ts has registered the global MeterProvider.
+export class AppMeter {
+  private meter: Meter;
+  private config: MetricsConfig;
+
+  constructor(
+    name: string,
+    version: string,
+    config: MetricsConfig = metricsConfig,
+    provider: MeterProvider = metrics.getMeterProvider(),
+  ) {
+    this.meter = provider.getMeter(name, version);
+    this.config = config;
+  }
+
+  // Prefixes a name with the configured namespace, e.g. batch_bytes -> appsentry_batch_bytes
+  namespaced(name: string): string {
+    return `${this.config.namespace}_${name}`;
+  }
+
+  createCounter(name: string, options?: MetricOptions): Counter {
+    const counter = this.meter.createCounter(name, options);
+    if (!this.hasConstLabels()) return counter;
+    return { add: (value, attributes, context) => counter.add(value, this.labels(attributes), context) };
+  }
+
+  createUpDownCounter(name: string, options?: MetricOptions): UpDownCounter {
+    const counter = this.meter.createUpDownCounter(name, options);
+    if (!this.hasConstLabels()) return counter;
+    return { add: (value, attributes, context) => counter.add(value, this.labels(attributes), context) };
+  }
+
+  createObservableGauge(name: string, options?: MetricOptions): ObservableGauge {
+    const gauge = this.meter.createObservableGauge(name, options);
+    if (!this.hasConstLabels()) return gauge;
+
+    // Callbacks are wrapped to add the labels, keyed by the caller's callback for removal
+    const wrapped = new Map<ObservableCallback, ObservableCallback>();
+    return {
+      addCallback: (callback) => {
+        const withLabels: ObservableCallback = (result) =>
+          callback({ observe: (value, attributes) => result.observe(value, this.labels(attributes)) });
+        wrapped.set(callback, withLabels);
+        gauge.addCallback(withLabels);
+      },
+      removeCallback: (callback) => {
+        const withLabels = wrapped.get(callback);
+        if (!withLabels) return;
+        wrapped.delete(callback);
+        gauge.removeCallback(withLabels);
+      },
+    };
+  }
+
+  // Configured buckets take precedence over the advice the instrument is created with
+  createHistogram(name: string, options: MetricOptions = {}): Histogram {
+    const buckets = this.config.histogramBuckets[name];
+    const histogram = buckets
+      ? this.meter.createHistogram(name, { ...options, advice: { ...options.advice, explicitBucketBoundaries: buckets } })
+      : this.meter.createHistogram(name, options);
+    if (!this.hasConstLabels()) return histogram;
+    return { record: (value, attributes, context) => histogram.record(value, this.labels(attributes), context) };
+  }
+
+  private hasConstLabels(): boolean {
+    return Object.keys(this.config.constLabels).length > 0;
+  }
+
+  // Const labels win over a recorded attribute of the same name so every series carries them
+  private labels(attributes?: Attributes): Attributes {
+    return { ...attributes, ...this.config.constLabels };
+  }
+}
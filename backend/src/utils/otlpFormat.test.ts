@@ -0,0 +1,57 @@
+import { describe, it } from 'node:test';
+import assert from 'node:assert/strict';
+import { encodeId, normalizeSeverity, SPAN_ID_PATTERN, TRACE_ID_PATTERN } from './otlpFormat';
+
+describe('normalizeSeverity', () => {
+  it('derives the text from the number', () => {
+    assert.deepEqual(normalizeSeverity({ severityNumber: 17 }), { text: 'ERROR', number: 17 });
+    assert.deepEqual(normalizeSeverity({ severityNumber: 14 }), { text: 'WARN', number: 14 });
+  });
+
+  it('derives the number from the text, including aliases', () => {
+    assert.deepEqual(normalizeSeverity({ severityText: 'warning' }), { text: 'warning', number: 13 });
+    assert.deepEqual(normalizeSeverity({ severityText: 'CRITICAL' }), { text: 'CRITICAL', number: 21 });
+  });
+
+  it('parses enum names sent by OTLP/JSON', () => {
+    assert.deepEqual(normalizeSeverity({ severityNumber: 'SEVERITY_NUMBER_INFO2' }), { text: 'INFO', number: 10 });
+    assert.deepEqual(normalizeSeverity({ severityNumber: 'SEVERITY_NUMBER_DEBUG' }), { text: 'DEBUG', number: 5 });
+  });
+
+  it('keeps both when both are set', () => {
+    assert.deepEqual(normalizeSeverity({ severityText: 'Notice', severityNumber: 10 }), { text: 'Notice', number: 10 });
+  });
+
+  it('defaults to INFO', () => {
+    assert.deepEqual(normalizeSeverity({}), { text: 'INFO', number: 9 });
+    assert.deepEqual(normalizeSeverity({ severityText: 'verbose' }), { text: 'verbose', number: 9 });
+  });
+});
+
+describe('encodeId', () => {
+  const traceId = '5B8EFFF798038103D269B633813FC60C';
+  const spanId = 'EEE19B7EC3C1B174';
+
+  it('lowercases hex by default', () => {
+    assert.equal(encodeId(traceId, TRACE_ID_PATTERN, true, 'hex'), traceId.toLowerCase());
+  });
+
+  it('encodes URL-safe base64 without padding', () => {
+    assert.equal(encodeId(spanId, SPAN_ID_PATTERN, false, 'base64'), '7uGbfsPBsXQ');
+    // 0xfb 0xff bytes map to + and / in standard base64
+    const encoded = encodeId('fbfffbfffbfffbfffbfffbfffbfffbff', TRACE_ID_PATTERN, true, 'base64');
+    assert.equal(encoded, '-__7__v_-__7__v_-__7_w');
+    assert.doesNotMatch(encoded, /[+/=]/);
+  });
+
+  it('formats trace IDs as uuid but leaves span IDs as hex', () => {
+    assert.equal(encodeId(traceId, TRACE_ID_PATTERN, true, 'uuid'), '5b8efff7-9803-8103-d269-b633813fc60c');
+    assert.equal(encodeId(spanId, SPAN_ID_PATTERN, false, 'uuid'), spanId.toLowerCase());
+  });
+
+  it('passes malformed IDs through and blanks missing ones', () => {
+    assert.equal(encodeId('not-hex', TRACE_ID_PATTERN, true, 'base64'), 'not-hex');
+    assert.equal(encodeId(undefined, TRACE_ID_PATTERN, true, 'hex'), '');
+    assert.equal(encodeId('', SPAN_ID_PATTERN, false, 'hex'), '');
+  });
+});
@@ -0,0 +1,83 @@
+// Lowest OTLP severity number of each severity range (e.g. INFO covers 9-12)
+const SEVERITY_RANGES: Record<string, number> = {
+  TRACE: 1,
+  DEBUG: 5,
+  INFO: 9,
+  WARN: 13,
+  ERROR: 17,
+  FATAL: 21,
+};
+
+const SEVERITY_ALIASES: Record<string, string> = {
+  WARNING: 'WARN',
+  CRITICAL: 'FATAL',
+};
+
+// Accepts numbers or enum names like SEVERITY_NUMBER_INFO2
+const parseSeverityNumber = (value: unknown): number => {
+  if (typeof value === 'number') return value;
+  if (typeof value !== 'string') return 0;
+
+  const match = value.match(/^SEVERITY_NUMBER_([A-Z]+)(\d)?$/);
+  if (match && SEVERITY_RANGES[match[1]] !== undefined) {
+    return SEVERITY_RANGES[match[1]] + (match[2] ? parseInt(match[2]) - 1 : 0);
+  }
+  return parseInt(value) || 0;
+};
+
+const severityTextFromNumber = (severityNumber: number): string => {
+  let text = '';
+  Object.entries(SEVERITY_RANGES).forEach(([name, start]) => {
+    if (severityNumber >= start && severityNumber <= 24) text = name;
+  });
+  return text;
+};
+
+const severityNumberFromText = (severityText: string): number => {
+  const name = severityText.trim().toUpperCase();
+  return SEVERITY_RANGES[SEVERITY_ALIASES[name] || name] || 0;
+};
+
+// Producers often set only one of severityText/severityNumber; derive the missing one
+export const normalizeSeverity = (logRecord: any): { text: string; number: number } => {
+  let severityNumber = parseSeverityNumber(logRecord.severityNumber);
+  let severityText = logRecord.severityText || '';
+
+  if (!severityText && severityNumber > 0) {
+    severityText = severityTextFromNumber(severityNumber);
+  }
+  if (severityNumber === 0 && severityText) {
+    severityNumber = severityNumberFromText(severityText);
+  }
+
+  return {
+    text: severityText || 'INFO',
+    number: severityNumber || 9,
+  };
+};
+
+// OTLP/JSON encodes trace IDs as 16 bytes and span IDs as 8 bytes of hex
+export const TRACE_ID_PATTERN = /^[0-9a-f]{32}$/i;
+export const SPAN_ID_PATTERN = /^[0-9a-f]{16}$/i;
+
+export const isValidTraceId = (traceId: unknown): boolean =>
+  typeof traceId === 'string' && TRACE_ID_PATTERN.test(traceId);
+
+export const isValidSpanId = (spanId: unknown): boolean =>
+  typeof spanId === 'string' && SPAN_ID_PATTERN.test(spanId);
+
+// Re-encodes a hex ID as hex (default, as sent by OTLP/JSON), base64, or uuid.
+// base64 uses the URL-safe alphabet without padding so stored IDs can be used as-is in
+// paths such as /api/otel/traces/:traceId. Span IDs are only 8 bytes, so callers pass
+// allowUuid for trace IDs only.
+export const encodeId = (id: unknown, pattern: RegExp, allowUuid: boolean, format: string): string => {
+  if (typeof id !== 'string' || !id) return '';
+  // Leave anything that isn't well-formed hex untouched rather than mangling it
+  if (!pattern.test(id)) return id;
+  const hex = id.toLowerCase();
+  if (format === 'base64') return Buffer.from(hex, 'hex').toString('base64url');
+  if (format === 'uuid' && allowUuid) {
+    return `${hex.slice(0, 8)}-${hex.slice(8, 12)}-${hex.slice(12, 16)}-${hex.slice(16, 20)}-${hex.slice(20)}`;
+  }
+  return hex;
+};
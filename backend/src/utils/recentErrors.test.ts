@@ -0,0 +1,22 @@
+import { describe, it } from 'node:test';
+import assert from 'node:assert/strict';
+import { RecentErrors } from './recentErrors';
+
+describe('RecentErrors', () => {
+  it('keeps the newest errors and evicts the oldest past capacity', () => {
+    const errors = new RecentErrors(2);
+    errors.record('clickhouse', 'traces', new Error('first'));
+    errors.record('clickhouse', 'metrics', new Error('second'));
+    errors.record('transform', 'logs', 'third');
+
+    const entries = errors.list();
+    assert.deepEqual(
+      entries.map(({ component, signal, message }) => ({ component, signal, message })),
+      [
+        { component: 'clickhouse', signal: 'metrics', message: 'second' },
+        { component: 'transform', signal: 'logs', message: 'third' },
+      ],
+    );
+    assert.ok(!Number.isNaN(Date.parse(entries[0].timestamp)));
+  });
+});
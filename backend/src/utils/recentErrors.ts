@@ -0,0 +1,35 @@
+export interface RecentError {
+  timestamp: string;
+  component: string;
+  signal: string;
+  message: string;
+}
+
+// Bounded buffer of the most recent processing errors, oldest evicted first
+export class RecentErrors {
+  private entries: RecentError[] = [];
+  private capacity: number;
+
+  constructor(capacity: number) {
+    this.capacity = Math.max(1, capacity);
+  }
+
+  record(component: string, signal: string, error: unknown): void {
+    this.entries.push({
+      timestamp: new Date().toISOString(),
+      component,
+      signal,
+      message: error instanceof Error ? error.message : String(error),
+    });
+
+    if (this.entries.length > this.capacity) {
+      this.entries.splice(0, this.entries.length - this.capacity);
+    }
+  }
+
+  list(): RecentError[] {
+    return [...this.entries];
+  }
+}
+
+export const recentErrors = new RecentErrors(parseInt(process.env.RECENT_ERRORS_CAPACITY || '100'));
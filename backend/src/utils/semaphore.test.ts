@@ -0,0 +1,55 @@
+import { describe, it } from 'node:test';
+import assert from 'node:assert/strict';
+import { Semaphore, SemaphoreTimeoutError } from './semaphore';
+
+const sleep = (ms: number) => new Promise((resolve) => setTimeout(resolve, ms));
+
+describe('Semaphore', () => {
+  it('never runs more than the limit at once', async () => {
+    const semaphore = new Semaphore(2);
+    let running = 0;
+    let peak = 0;
+    const task = async () => {
+      running++;
+      peak = Math.max(peak, running);
+      await sleep(5);
+      running--;
+    };
+
+    await Promise.all(Array.from({ length: 6 }, () => semaphore.run(task)));
+    assert.equal(peak, 2);
+    assert.equal(semaphore.inFlight, 0);
+    assert.equal(semaphore.waiting, 0);
+  });
+
+  it('rejects a waiter after the timeout and keeps the slot count intact', async () => {
+    const semaphore = new Semaphore(1);
+    let release!: () => void;
+    const holder = semaphore.run(() => new Promise<void>((resolve) => (release = resolve)));
+
+    await assert.rejects(semaphore.run(async () => 'late', 10), SemaphoreTimeoutError);
+    assert.equal(semaphore.waiting, 0);
+
+    release();
+    await holder;
+    assert.equal(semaphore.inFlight, 0);
+    assert.equal(await semaphore.run(async () => 'next', 10), 'next');
+  });
+
+  it('does not limit with a zero limit', async () => {
+    const semaphore = new Semaphore(0);
+    let running = 0;
+    let peak = 0;
+    await Promise.all(
+      Array.from({ length: 4 }, () =>
+        semaphore.run(async () => {
+          running++;
+          peak = Math.max(peak, running);
+          await sleep(5);
+          running--;
+        }),
+      ),
+    );
+    assert.equal(peak, 4);
+  });
+});
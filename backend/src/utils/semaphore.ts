@@ -0,0 +1,66 @@
+export class SemaphoreTimeoutError extends Error {
+  constructor(timeoutMs: number) {
+    super(`Timed out after ${timeoutMs}ms waiting for a free slot`);
+    this.name = 'SemaphoreTimeoutError';
+  }
+}
+
+// Limits concurrent async work; callers beyond the limit wait for a free slot,
+// optionally giving up after timeoutMs
+export class Semaphore {
+  private limit: number;
+  private active = 0;
+  private waiters: Array<() => void> = [];
+
+  constructor(limit: number) {
+    this.limit = limit;
+  }
+
+  get inFlight(): number {
+    return this.active;
+  }
+
+  get waiting(): number {
+    return this.waiters.length;
+  }
+
+  async run<T>(task: () => Promise<T>, timeoutMs = 0): Promise<T> {
+    await this.acquire(timeoutMs);
+    try {
+      return await task();
+    } finally {
+      this.release();
+    }
+  }
+
+  private async acquire(timeoutMs: number): Promise<void> {
+    if (this.limit <= 0 || this.active < this.limit) {
+      this.active++;
+      return;
+    }
+    // The releasing caller hands its slot over, so active stays unchanged
+    await new Promise<void>((resolve, reject) => {
+      let timer: NodeJS.Timeout | undefined;
+      const waiter = () => {
+        if (timer) clearTimeout(timer);
+        resolve();
+      };
+      if (timeoutMs > 0) {
+        timer = setTimeout(() => {
+          this.waiters.splice(this.waiters.indexOf(waiter), 1);
+          reject(new SemaphoreTimeoutError(timeoutMs));
+        }, timeoutMs);
+      }
+      this.waiters.push(waiter);
+    });
+  }
+
+  private release(): void {
+    const next = this.waiters.shift();
+    if (next) {
+      next();
+    } else {
+      this.active--;
+    }
+  }
+}
@@ -0,0 +1,53 @@
+import { describe, it } from 'node:test';
+import assert from 'node:assert/strict';
+import { ServiceTracker } from './serviceTracker';
+
+const secondsFor = (tracker: ServiceTracker, now: number) =>
+  Object.fromEntries(tracker.list(now).map((entry) => [entry.serviceName, entry.secondsSinceLastSeen]));
+
+describe('ServiceTracker', () => {
+  it('reports growing seconds for a service that stops sending', () => {
+    const tracker = new ServiceTracker(10, 60000);
+    tracker.markSeen('checkout', 0);
+    tracker.markSeen('payments', 0);
+
+    tracker.markSeen('payments', 30000);
+    assert.deepEqual(secondsFor(tracker, 30000), { checkout: 30, payments: 0 });
+
+    tracker.markSeen('payments', 90000);
+    assert.deepEqual(secondsFor(tracker, 90000), { checkout: 90, payments: 0 });
+    assert.equal(tracker.countDistinct(90000), 1);
+  });
+
+  it('evicts silent services at the cap before refusing new names', () => {
+    const tracker = new ServiceTracker(2, 60000);
+    tracker.markSeen('a', 0);
+    tracker.markSeen('b', 50000);
+
+    tracker.markSeen('c', 70000);
+    assert.deepEqual(Object.keys(secondsFor(tracker, 70000)).sort(), ['b', 'c']);
+
+    tracker.markSeen('d', 80000);
+    assert.deepEqual(Object.keys(secondsFor(tracker, 80000)).sort(), ['b', 'c']);
+    assert.equal(tracker.capped, true);
+  });
+
+  it('counts distinct services seen within the window', () => {
+    const tracker = new ServiceTracker(10, 60000);
+    tracker.markSeen('checkout', 0);
+    tracker.markSeen('checkout', 10000);
+    tracker.markSeen('payments', 20000);
+    tracker.markSeen('search', 30000);
+    assert.equal(tracker.countDistinct(30000), 3);
+
+    // checkout was last seen at 10s, so it drops out of the window after 70s
+    assert.equal(tracker.countDistinct(75000), 2);
+    assert.equal(tracker.countDistinct(200000), 0);
+  });
+
+  it('ignores the unknown placeholder', () => {
+    const tracker = new ServiceTracker(10, 60000);
+    tracker.markSeen('unknown', 0);
+    assert.deepEqual(tracker.list(0), []);
+  });
+});
@@ -0,0 +1,50 @@
+export interface ServiceLastSeen {
+  serviceName: string;
+  lastSeen: number;
+  secondsSinceLastSeen: number;
+}
+
+// Last time each service sent any signal, for spotting services that went silent. At
+// maxServices, services silent for longer than the window make room before a new name is refused.
+export class ServiceTracker {
+  private maxServices: number;
+  private windowMs: number;
+  private lastSeen = new Map<string, number>();
+
+  constructor(maxServices: number, windowMs: number) {
+    this.maxServices = maxServices;
+    this.windowMs = windowMs;
+  }
+
+  get capped(): boolean {
+    return this.lastSeen.size >= this.maxServices;
+  }
+
+  markSeen(serviceName: string, now = Date.now()): void {
+    if (!serviceName || serviceName === 'unknown') return;
+    if (!this.lastSeen.has(serviceName) && this.capped) {
+      this.lastSeen.forEach((lastSeen, name) => {
+        if (now - lastSeen > this.windowMs) this.lastSeen.delete(name);
+      });
+      if (this.capped) return;
+    }
+    this.lastSeen.set(serviceName, now);
+  }
+
+  // Distinct services seen within the window; saturates at maxServices
+  countDistinct(now = Date.now()): number {
+    let count = 0;
+    this.lastSeen.forEach((lastSeen) => {
+      if (now - lastSeen <= this.windowMs) count++;
+    });
+    return count;
+  }
+
+  list(now = Date.now()): ServiceLastSeen[] {
+    return Array.from(this.lastSeen.entries()).map(([serviceName, lastSeen]) => ({
+      serviceName,
+      lastSeen,
+      secondsSinceLastSeen: Math.floor((now - lastSeen) / 1000),
+    }));
+  }
+}
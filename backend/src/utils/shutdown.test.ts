@@ -0,0 +1,44 @@
+import { describe, it } from 'node:test';
+import assert from 'node:assert/strict';
+import { runShutdownPhases, shutdownPhases } from './shutdown';
+
+const sleep = (ms: number) => new Promise((resolve) => setTimeout(resolve, ms));
+
+describe('shutdown phases', () => {
+  it('drains the HTTP server before flushing telemetry and closing ClickHouse', async () => {
+    const events: string[] = [];
+    const phases = shutdownPhases({
+      server: {
+        close: async () => {
+          events.push('server:start');
+          await sleep(10);
+          events.push('server:done');
+        },
+      },
+      telemetry: { shutdown: async () => void events.push('otel') },
+      clickhouse: { close: async () => void events.push('clickhouse') },
+    });
+
+    assert.deepEqual(
+      phases.map(([name]) => name),
+      ['http-server', 'otel-sdk', 'clickhouse'],
+    );
+    await runShutdownPhases(phases);
+    assert.deepEqual(events, ['server:start', 'server:done', 'otel', 'clickhouse']);
+  });
+
+  it('keeps going after a failed phase and skips a server that never started', async () => {
+    const events: string[] = [];
+    await runShutdownPhases(
+      shutdownPhases({
+        telemetry: {
+          shutdown: async () => {
+            throw new Error('exporter unreachable');
+          },
+        },
+        clickhouse: { close: async () => void events.push('clickhouse') },
+      }),
+    );
+    assert.deepEqual(events, ['clickhouse']);
+  });
+});
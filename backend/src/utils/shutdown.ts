@@ -0,0 +1,34 @@
+import { logger } from './logger';
+
+export type ShutdownPhase = [name: string, run: () => Promise<void>];
+
+export interface ShutdownTargets {
+  // Undefined until the server has been created
+  server?: { close(): Promise<void> };
+  telemetry: { shutdown(): Promise<void> };
+  clickhouse: { close(): Promise<void> };
+}
+
+// The HTTP server drains first so in-flight requests can still write to ClickHouse, and
+// ClickHouse closes last
+export const shutdownPhases = (targets: ShutdownTargets): ShutdownPhase[] => [
+  ['http-server', async () => targets.server?.close()],
+  ['otel-sdk', () => targets.telemetry.shutdown()],
+  ['clickhouse', () => targets.clickhouse.close()],
+];
+
+// Runs each shutdown step in order, logging when it starts and how long it took,
+// so a hung termination shows which phase it is stuck in. A failed phase doesn't
+// stop the ones after it.
+export const runShutdownPhases = async (phases: ShutdownPhase[]): Promise<void> => {
+  for (const [phase, run] of phases) {
+    const startedAt = Date.now();
+    logger.info('Shutdown phase started', { phase, startedAt: new Date(startedAt).toISOString() });
+    try {
+      await run();
+      logger.info('Shutdown phase completed', { phase, durationMs: Date.now() - startedAt });
+    } catch (error) {
+      logger.error('Shutdown phase failed', { phase, durationMs: Date.now() - startedAt, error });
+    }
+  }
+};
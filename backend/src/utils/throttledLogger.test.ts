@@ -0,0 +1,26 @@
+import { describe, it, mock } from 'node:test';
+import assert from 'node:assert/strict';
+import { logger } from './logger';
+import { logThrottledError } from './throttledLogger';
+
+describe('logThrottledError', () => {
+  it('logs a repeated error once per interval and reports the suppressed count', () => {
+    let now = 1700000000000;
+    const clock = mock.method(Date, 'now', () => now);
+    const error = mock.method(logger, 'error', () => logger);
+    try {
+      logThrottledError('clickhouse-down', 'Insert failed');
+      logThrottledError('clickhouse-down', 'Insert failed');
+      logThrottledError('clickhouse-down', 'Insert failed');
+      assert.equal(error.mock.callCount(), 1);
+
+      now += 30000;
+      logThrottledError('clickhouse-down', 'Insert failed');
+      assert.equal(error.mock.callCount(), 2);
+      assert.deepEqual(error.mock.calls[1].arguments, ['Insert failed', { suppressed: 2 }]);
+    } finally {
+      clock.mock.restore();
+      error.mock.restore();
+    }
+  });
+});
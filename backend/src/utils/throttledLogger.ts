@@ -0,0 +1,18 @@
+import { logger } from './logger';
+
+const INTERVAL_MS = parseInt(process.env.LOG_THROTTLE_INTERVAL_MS || '30000');
+const lastLogged = new Map<string, { at: number; suppressed: number }>();
+
+// Logs an error at most once per interval per key, reporting how many repeats were suppressed
+export const logThrottledError = (key: string, message: string, meta: Record<string, unknown> = {}): void => {
+  const now = Date.now();
+  const entry = lastLogged.get(key);
+
+  if (entry && now - entry.at < INTERVAL_MS) {
+    entry.suppressed++;
+    return;
+  }
+
+  logger.error(message, entry?.suppressed ? { ...meta, suppressed: entry.suppressed } : meta);
+  lastLogged.set(key, { at: now, suppressed: 0 });
+};